@@ -15,6 +15,7 @@ import (
 	"github.com/hashicorp/go-cleanhttp"
 	"github.com/hashicorp/go-rootcerts"
 	"github.com/sethgrid/pester"
+	"golang.org/x/net/context"
 )
 
 const EnvVaultAddress = "VAULT_ADDR"
@@ -287,19 +288,32 @@ func (c *Client) NewRequest(method, path string) *Request {
 // a Vault server not configured with this client. This is an advanced operation
 // that generally won't need to be called externally.
 func (c *Client) RawRequest(r *Request) (*Response, error) {
+	return c.RawRequestWithContext(context.Background(), r)
+}
+
+// RawRequestWithContext performs the raw request given, returning early with
+// the context's error if the context is canceled or its deadline passes
+// before the request completes. This is an advanced operation that generally
+// won't need to be called externally.
+func (c *Client) RawRequestWithContext(ctx context.Context, r *Request) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	redirectCount := 0
 START:
 	req, err := r.ToHTTP()
 	if err != nil {
 		return nil, err
 	}
+	req.Cancel = ctx.Done()
 
 	client := pester.NewExtendedClient(c.config.HttpClient)
 	client.Backoff = pester.LinearJitterBackoff
 	client.MaxRetries = c.config.MaxRetries
 
 	var result *Response
-	resp, err := client.Do(req)
+	resp, err := doWithContext(ctx, client, req)
 	if resp != nil {
 		result = &Response{Response: resp}
 	}
@@ -356,3 +370,34 @@ START:
 
 	return result, nil
 }
+
+// doWithContext performs the request with the given client, returning as
+// soon as the context is done rather than waiting out any retries. A
+// response that arrives after that is closed.
+func doWithContext(ctx context.Context, client *pester.Client, req *http.Request) (*http.Response, error) {
+	if ctx.Done() == nil {
+		return client.Do(req)
+	}
+
+	type doResult struct {
+		resp *http.Response
+		err  error
+	}
+	doneCh := make(chan doResult, 1)
+	go func() {
+		resp, err := client.Do(req)
+		doneCh <- doResult{resp, err}
+	}()
+
+	select {
+	case result := <-doneCh:
+		return result.resp, result.err
+	case <-ctx.Done():
+		go func() {
+			if result := <-doneCh; result.resp != nil && result.resp.Body != nil {
+				result.resp.Body.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
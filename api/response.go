@@ -3,6 +3,7 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -54,6 +55,22 @@ func (r *Response) Error() error {
 			r.StatusCode, bodyBuf.String())
 	}
 
+	if resp.Code != "" {
+		return &ResponseError{
+			StatusCode: r.StatusCode,
+			Code:       resp.Code,
+			Errors:     resp.Errors,
+			Nonce:      resp.Nonce,
+			message:    formatErrors(r, resp.Errors),
+		}
+	}
+
+	return errors.New(formatErrors(r, resp.Errors))
+}
+
+// formatErrors formats the errors returned by the HTTP API for the given
+// response.
+func formatErrors(r *Response, errs []string) string {
 	var errBody bytes.Buffer
 	errBody.WriteString(fmt.Sprintf(
 		"Error making API request.\n\n"+
@@ -61,15 +78,35 @@ func (r *Response) Error() error {
 			"Code: %d. Errors:\n\n",
 		r.Request.Method, r.Request.URL.String(),
 		r.StatusCode))
-	for _, err := range resp.Errors {
+	for _, err := range errs {
 		errBody.WriteString(fmt.Sprintf("* %s", err))
 	}
 
-	return fmt.Errorf(errBody.String())
+	return errBody.String()
 }
 
 // ErrorResponse is the raw structure of errors when they're returned by the
 // HTTP API.
 type ErrorResponse struct {
 	Errors []string
+	Code   string
+	Nonce  string
+}
+
+// ResponseError is returned by Response.Error when the HTTP API includes a
+// machine-readable code along with the errors.
+type ResponseError struct {
+	StatusCode int
+	Code       string
+	Errors     []string
+
+	// Nonce is the nonce of the running root generation attempt, if the
+	// error reports one
+	Nonce string
+
+	message string
+}
+
+func (e *ResponseError) Error() string {
+	return e.message
 }
@@ -1,10 +1,81 @@
 package api
 
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// GenerateRootOTPLength is the length in bytes of the one-time pad used to
+// encode a generated root token; it matches the length of the raw token.
+const GenerateRootOTPLength = 16
+
+// ErrGenerateRootSealed is returned by the root generation methods when the
+// Vault is sealed, since root generation can only be done while unsealed.
+var ErrGenerateRootSealed = errors.New("Vault must be unsealed to use generate-root")
+
+var (
+	// ErrGenerateRootNotStarted is returned when a key is provided but no
+	// root generation is in progress.
+	ErrGenerateRootNotStarted = errors.New("no root generation in progress")
+
+	// ErrGenerateRootNonceRequired is returned when a key is provided
+	// without a nonce.
+	ErrGenerateRootNonceRequired = errors.New("nonce required for root generation")
+
+	// ErrGenerateRootNonceMismatch is returned when a key is provided with a
+	// nonce that is not the nonce of the in-progress attempt.
+	ErrGenerateRootNonceMismatch = errors.New("incorrect nonce supplied for root generation")
+
+	// ErrGenerateRootInvalidKey is returned when a provided key is rejected.
+	ErrGenerateRootInvalidKey = errors.New("invalid key provided for root generation")
+
+	// ErrGenerateRootInProgress is returned when starting a root generation
+	// while another attempt is in progress.
+	ErrGenerateRootInProgress = errors.New("root generation already in progress")
+
+	// ErrGenerateRootRateLimited is returned when too many keys have been
+	// provided in a short time.
+	ErrGenerateRootRateLimited = errors.New("too many root generation updates")
+
+	// ErrGenerateRootCanceled is returned when an invalid key cancels the
+	// attempt because too many invalid keys were provided.
+	ErrGenerateRootCanceled = errors.New("root generation canceled after too many invalid keys")
+
+	// ErrGenerateRootLockedOut is returned while root generation is refused
+	// after an attempt was canceled.
+	ErrGenerateRootLockedOut = errors.New("root generation is locked out")
+)
+
+// generateRootErrors maps the error codes returned by the HTTP API to the
+// errors above. Errors with these codes are returned as a *GenerateRootError.
+var generateRootErrors = map[string]error{
+	"not_started":    ErrGenerateRootNotStarted,
+	"nonce_required": ErrGenerateRootNonceRequired,
+	"nonce_mismatch": ErrGenerateRootNonceMismatch,
+	"invalid_key":    ErrGenerateRootInvalidKey,
+	"in_progress":    ErrGenerateRootInProgress,
+	"rate_limited":   ErrGenerateRootRateLimited,
+	"canceled":       ErrGenerateRootCanceled,
+	"locked_out":     ErrGenerateRootLockedOut,
+}
+
 func (c *Sys) GenerateRootStatus() (*GenerateRootStatusResponse, error) {
+	return c.GenerateRootStatusWithContext(context.Background())
+}
+
+// GenerateRootStatusWithContext is like GenerateRootStatus, but the request
+// is abandoned once the context is done.
+func (c *Sys) GenerateRootStatusWithContext(ctx context.Context) (*GenerateRootStatusResponse, error) {
 	r := c.c.NewRequest("GET", "/v1/sys/generate-root/attempt")
-	resp, err := c.c.RawRequest(r)
+	resp, err := c.c.RawRequestWithContext(ctx, r)
 	if err != nil {
-		return nil, err
+		return nil, c.generateRootError(ctx, resp, err)
 	}
 	defer resp.Body.Close()
 
@@ -14,9 +85,37 @@ func (c *Sys) GenerateRootStatus() (*GenerateRootStatusResponse, error) {
 }
 
 func (c *Sys) GenerateRootInit(otp, pgpKey string) (*GenerateRootStatusResponse, error) {
+	return c.GenerateRootInitWithContext(context.Background(), otp, pgpKey)
+}
+
+// GenerateRootInitWithContext is like GenerateRootInit, but the request is
+// abandoned once the context is done.
+func (c *Sys) GenerateRootInitWithContext(ctx context.Context, otp, pgpKey string) (*GenerateRootStatusResponse, error) {
+	return c.GenerateRootInitWithOptionsContext(ctx, &GenerateRootInitOptions{
+		OTP:    otp,
+		PGPKey: pgpKey,
+	})
+}
+
+// GenerateRootInitWithOptions starts a root generation attempt using the
+// given options. Nil options are treated as empty options.
+func (c *Sys) GenerateRootInitWithOptions(opts *GenerateRootInitOptions) (*GenerateRootStatusResponse, error) {
+	return c.GenerateRootInitWithOptionsContext(context.Background(), opts)
+}
+
+// GenerateRootInitWithOptionsContext is like GenerateRootInitWithOptions, but
+// the request is abandoned once the context is done.
+func (c *Sys) GenerateRootInitWithOptionsContext(ctx context.Context, opts *GenerateRootInitOptions) (*GenerateRootStatusResponse, error) {
+	if opts == nil {
+		opts = &GenerateRootInitOptions{}
+	}
+
 	body := map[string]interface{}{
-		"otp":     otp,
-		"pgp_key": pgpKey,
+		"otp":     opts.OTP,
+		"pgp_key": opts.PGPKey,
+	}
+	if opts.TTL != 0 {
+		body["ttl"] = opts.TTL.String()
 	}
 
 	r := c.c.NewRequest("PUT", "/v1/sys/generate-root/attempt")
@@ -24,9 +123,9 @@ func (c *Sys) GenerateRootInit(otp, pgpKey string) (*GenerateRootStatusResponse,
 		return nil, err
 	}
 
-	resp, err := c.c.RawRequest(r)
+	resp, err := c.c.RawRequestWithContext(ctx, r)
 	if err != nil {
-		return nil, err
+		return nil, c.generateRootError(ctx, resp, err)
 	}
 	defer resp.Body.Close()
 
@@ -36,15 +135,28 @@ func (c *Sys) GenerateRootInit(otp, pgpKey string) (*GenerateRootStatusResponse,
 }
 
 func (c *Sys) GenerateRootCancel() error {
+	return c.GenerateRootCancelWithContext(context.Background())
+}
+
+// GenerateRootCancelWithContext is like GenerateRootCancel, but the request
+// is abandoned once the context is done.
+func (c *Sys) GenerateRootCancelWithContext(ctx context.Context) error {
 	r := c.c.NewRequest("DELETE", "/v1/sys/generate-root/attempt")
-	resp, err := c.c.RawRequest(r)
-	if err == nil {
-		defer resp.Body.Close()
+	resp, err := c.c.RawRequestWithContext(ctx, r)
+	if err != nil {
+		return c.generateRootError(ctx, resp, err)
 	}
-	return err
+	defer resp.Body.Close()
+	return nil
 }
 
 func (c *Sys) GenerateRootUpdate(shard, nonce string) (*GenerateRootStatusResponse, error) {
+	return c.GenerateRootUpdateWithContext(context.Background(), shard, nonce)
+}
+
+// GenerateRootUpdateWithContext is like GenerateRootUpdate, but the request
+// is abandoned once the context is done.
+func (c *Sys) GenerateRootUpdateWithContext(ctx context.Context, shard, nonce string) (*GenerateRootStatusResponse, error) {
 	body := map[string]interface{}{
 		"key":   shard,
 		"nonce": nonce,
@@ -55,9 +167,9 @@ func (c *Sys) GenerateRootUpdate(shard, nonce string) (*GenerateRootStatusRespon
 		return nil, err
 	}
 
-	resp, err := c.c.RawRequest(r)
+	resp, err := c.c.RawRequestWithContext(ctx, r)
 	if err != nil {
-		return nil, err
+		return nil, c.generateRootError(ctx, resp, err)
 	}
 	defer resp.Body.Close()
 
@@ -66,12 +178,151 @@ func (c *Sys) GenerateRootUpdate(shard, nonce string) (*GenerateRootStatusRespon
 	return &result, err
 }
 
+// GenerateRootUpdateMany submits each of the given shards in turn, stopping
+// once the attempt is complete, and returns the final status. If a shard is
+// rejected the returned error is a *GenerateRootUpdateManyError reporting how
+// many shards were accepted first.
+func (c *Sys) GenerateRootUpdateMany(shards []string, nonce string) (*GenerateRootStatusResponse, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("no shards provided")
+	}
+
+	var result *GenerateRootStatusResponse
+	for i, shard := range shards {
+		var err error
+		result, err = c.GenerateRootUpdate(shard, nonce)
+		if err == ErrGenerateRootSealed {
+			return nil, err
+		}
+		if err != nil {
+			return nil, &GenerateRootUpdateManyError{
+				Accepted: i,
+				Total:    len(shards),
+				Err:      err,
+			}
+		}
+		if result.Complete {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// GenerateRootOTP returns a random, base64-encoded one-time pad suitable for
+// passing to GenerateRootInit. The pad is generated locally and is never
+// sent to the server until the attempt is initialized.
+func (c *Sys) GenerateRootOTP() (string, error) {
+	return GenerateRootOTP()
+}
+
+// GenerateRootOTP is like Sys.GenerateRootOTP, but does not need a client.
+func GenerateRootOTP() (string, error) {
+	buf := make([]byte, GenerateRootOTPLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("error reading random bytes: %s", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// generateRootError inspects a failed root generation request and returns
+// ErrGenerateRootSealed if it failed because the Vault is sealed, or a
+// *GenerateRootError if the server reported a known error code; otherwise
+// the original error is returned. The seal status is checked using the given
+// context.
+func (c *Sys) generateRootError(ctx context.Context, resp *Response, err error) error {
+	if respErr, ok := err.(*ResponseError); ok {
+		if codeErr, ok := generateRootErrors[respErr.Code]; ok {
+			err = &GenerateRootError{
+				ResponseError: respErr,
+				Err:           codeErr,
+			}
+		}
+	}
+
+	if resp == nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		return err
+	}
+
+	// A 503 may also mean there is no active node, so confirm the seal
+	// status before reporting the Vault as sealed
+	sealStatus, sealErr := c.SealStatusWithContext(ctx)
+	if sealErr == nil && sealStatus.Sealed {
+		return ErrGenerateRootSealed
+	}
+	return err
+}
+
+// GenerateRootError is returned by the root generation methods when the
+// server rejects a request with a known error code. It keeps the server's
+// message and, for ErrGenerateRootInProgress, the nonce of the running
+// attempt. Err is one of the ErrGenerateRoot errors above; use
+// IsGenerateRootError to compare against them.
+type GenerateRootError struct {
+	*ResponseError
+
+	Err error
+}
+
+// IsGenerateRootError returns true if err is a *GenerateRootError, possibly
+// within a *GenerateRootUpdateManyError, for the given ErrGenerateRoot error.
+func IsGenerateRootError(err, target error) bool {
+	if manyErr, ok := err.(*GenerateRootUpdateManyError); ok {
+		err = manyErr.Err
+	}
+	genErr, ok := err.(*GenerateRootError)
+	return ok && genErr.Err == target
+}
+
+// GenerateRootUpdateManyError is returned by GenerateRootUpdateMany when a
+// shard is rejected. Err is the error for the rejected shard, usually a
+// *GenerateRootError.
+type GenerateRootUpdateManyError struct {
+	// Accepted is the number of shards accepted before the rejected one
+	Accepted int
+
+	// Total is the number of shards that were to be submitted
+	Total int
+
+	Err error
+}
+
+func (e *GenerateRootUpdateManyError) Error() string {
+	return fmt.Sprintf("shard %d of %d rejected after %d accepted: %s",
+		e.Accepted+1, e.Total, e.Accepted, e.Err)
+}
+
+// GenerateRootInitOptions are the options used to start a root generation
+// attempt. Exactly one of OTP or PGPKey must be set.
+type GenerateRootInitOptions struct {
+	// OTP is a base64-encoded one-time pad used to encode the root token
+	OTP string
+
+	// PGPKey is a base64-encoded PGP public key used to encrypt the root
+	// token
+	PGPKey string
+
+	// TTL is the TTL of the generated root token; zero means the token
+	// does not expire
+	TTL time.Duration
+}
+
 type GenerateRootStatusResponse struct {
-	Nonce            string
-	Started          bool
-	Progress         int
-	Required         int
-	Complete         bool
+	Nonce            string `json:"nonce"`
+	Started          bool   `json:"started"`
+	Progress         int    `json:"progress"`
+	Required         int    `json:"required"`
+	Remaining        int    `json:"remaining"`
+	Complete         bool   `json:"complete"`
 	EncodedRootToken string `json:"encoded_root_token"`
 	PGPFingerprint   string `json:"pgp_fingerprint"`
+	ExpiresIn        int    `json:"expires_in"`
+	StartedAt        string `json:"started_at"`
+	ExpiresAt        string `json:"expires_at"`
+	TTL              int    `json:"ttl"`
 }
@@ -0,0 +1,342 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/xor"
+	"golang.org/x/net/context"
+)
+
+func TestSysGenerateRootOTP(t *testing.T) {
+	client, err := NewClient(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otp, err := client.Sys().GenerateRootOTP()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	otpBytes, err := base64.StdEncoding.DecodeString(otp)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(otpBytes) != GenerateRootOTPLength {
+		t.Fatalf("bad: %d", len(otpBytes))
+	}
+
+	// Encoding a token with the OTP and decoding it again should give back
+	// the original token
+	token, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenBytes, err := uuid.ParseUUID(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := xor.XORBase64(otp, base64.StdEncoding.EncodeToString(tokenBytes))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	decoded, err := xor.XORBase64(base64.StdEncoding.EncodeToString(encoded), otp)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	decodedToken, err := uuid.FormatUUID(decoded)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if decodedToken != token {
+		t.Fatalf("bad: %s != %s", decodedToken, token)
+	}
+}
+
+func TestSysGenerateRootUpdateMany(t *testing.T) {
+	var submitted []string
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if body["key"] == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":["invalid key"],"code":"invalid_key"}`))
+			return
+		}
+		submitted = append(submitted, body["key"])
+		json.NewEncoder(w).Encode(&GenerateRootStatusResponse{
+			Nonce:    body["nonce"],
+			Started:  true,
+			Progress: len(submitted),
+			Required: 2,
+			Complete: len(submitted) == 2,
+		})
+	}
+
+	config, ln := testHTTPServer(t, http.HandlerFunc(handler))
+	defer ln.Close()
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Submission should stop once the attempt is complete
+	status, err := client.Sys().GenerateRootUpdateMany([]string{"a", "b", "c"}, "abcd")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !status.Complete || status.Progress != 2 {
+		t.Fatalf("bad: %#v", status)
+	}
+	if len(submitted) != 2 {
+		t.Fatalf("bad: %#v", submitted)
+	}
+
+	// A rejected shard should report how many were accepted
+	submitted = nil
+	_, err = client.Sys().GenerateRootUpdateMany([]string{"a", "bad", "c"}, "abcd")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "shard 2 of 3 rejected after 1 accepted") {
+		t.Fatalf("bad: %s", err)
+	}
+	manyErr, ok := err.(*GenerateRootUpdateManyError)
+	if !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+	if manyErr.Accepted != 1 || !IsGenerateRootError(err, ErrGenerateRootInvalidKey) {
+		t.Fatalf("bad: %#v", manyErr)
+	}
+	if len(submitted) != 1 {
+		t.Fatalf("bad: %#v", submitted)
+	}
+}
+
+func TestSysGenerateRoot_canceledContext(t *testing.T) {
+	blockCh := make(chan struct{})
+	defer close(blockCh)
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		<-blockCh
+	}
+
+	config, ln := testHTTPServer(t, http.HandlerFunc(handler))
+	defer ln.Close()
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys := client.Sys()
+
+	calls := map[string]func(context.Context) error{
+		"status": func(ctx context.Context) error {
+			_, err := sys.GenerateRootStatusWithContext(ctx)
+			return err
+		},
+		"init": func(ctx context.Context) error {
+			_, err := sys.GenerateRootInitWithContext(ctx, "", "")
+			return err
+		},
+		"cancel": func(ctx context.Context) error {
+			return sys.GenerateRootCancelWithContext(ctx)
+		},
+		"update": func(ctx context.Context) error {
+			_, err := sys.GenerateRootUpdateWithContext(ctx, "abcd", "abcd")
+			return err
+		},
+	}
+
+	for name, call := range calls {
+		// Already canceled
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := call(ctx); err != context.Canceled {
+			t.Fatalf("%s: bad: %v", name, err)
+		}
+
+		// Canceled while the request is in flight
+		ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		err := call(ctx)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("%s: bad: %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("%s: took too long: %s", name, elapsed)
+		}
+	}
+}
+
+func TestSysGenerateRoot_canceledContext_sealStatus(t *testing.T) {
+	blockCh := make(chan struct{})
+	defer close(blockCh)
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		// The seal status check made after a 503 never completes
+		if req.URL.Path == "/v1/sys/seal-status" {
+			<-blockCh
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	config, ln := testHTTPServer(t, http.HandlerFunc(handler))
+	defer ln.Close()
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.Sys().GenerateRootStatusWithContext(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("took too long: %s", elapsed)
+	}
+}
+
+func TestSysGenerateRoot_errors(t *testing.T) {
+	var code string
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		body := map[string]interface{}{
+			"errors": []string{"failed"},
+			"code":   code,
+		}
+		if code == "in_progress" {
+			body["nonce"] = "efgh"
+		}
+		json.NewEncoder(w).Encode(body)
+	}
+
+	config, ln := testHTTPServer(t, http.HandlerFunc(handler))
+	defer ln.Close()
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]error{
+		"not_started":    ErrGenerateRootNotStarted,
+		"nonce_required": ErrGenerateRootNonceRequired,
+		"nonce_mismatch": ErrGenerateRootNonceMismatch,
+		"invalid_key":    ErrGenerateRootInvalidKey,
+		"rate_limited":   ErrGenerateRootRateLimited,
+		"canceled":       ErrGenerateRootCanceled,
+		"locked_out":     ErrGenerateRootLockedOut,
+	}
+	for code = range cases {
+		_, err := client.Sys().GenerateRootUpdate("abcd", "abcd")
+		if !IsGenerateRootError(err, cases[code]) {
+			t.Fatalf("%s: bad: %v", code, err)
+		}
+
+		// The server's response is kept
+		genErr := err.(*GenerateRootError)
+		if genErr.Code != code || genErr.StatusCode != http.StatusBadRequest ||
+			!strings.Contains(genErr.Error(), "* failed") {
+			t.Fatalf("%s: bad: %#v", code, genErr)
+		}
+	}
+
+	code = "in_progress"
+	_, err = client.Sys().GenerateRootInit("abcd", "")
+	if !IsGenerateRootError(err, ErrGenerateRootInProgress) {
+		t.Fatalf("bad: %v", err)
+	}
+	if nonce := err.(*GenerateRootError).Nonce; nonce != "efgh" {
+		t.Fatalf("bad: %q", nonce)
+	}
+
+	// Unknown codes are passed through with the server's message
+	code = "unknown"
+	_, err = client.Sys().GenerateRootUpdate("abcd", "abcd")
+	respErr, ok := err.(*ResponseError)
+	if !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+	if respErr.Code != "unknown" || respErr.StatusCode != http.StatusBadRequest ||
+		!strings.Contains(respErr.Error(), "* failed") {
+		t.Fatalf("bad: %#v", respErr)
+	}
+
+	// Errors without a code are not typed
+	code = ""
+	_, err = client.Sys().GenerateRootUpdate("abcd", "abcd")
+	if _, ok := err.(*ResponseError); ok || err == nil {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
+func TestSysGenerateRootInitWithOptions(t *testing.T) {
+	var body map[string]interface{}
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		body = nil
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(&GenerateRootStatusResponse{
+			Started: true,
+		})
+	}
+
+	config, ln := testHTTPServer(t, http.HandlerFunc(handler))
+	defer ln.Close()
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		opts     *GenerateRootInitOptions
+		expected map[string]interface{}
+	}{
+		{
+			nil,
+			map[string]interface{}{"otp": "", "pgp_key": ""},
+		},
+		{
+			&GenerateRootInitOptions{OTP: "abcd"},
+			map[string]interface{}{"otp": "abcd", "pgp_key": ""},
+		},
+		{
+			&GenerateRootInitOptions{PGPKey: "efgh"},
+			map[string]interface{}{"otp": "", "pgp_key": "efgh"},
+		},
+		{
+			&GenerateRootInitOptions{OTP: "abcd", TTL: time.Hour},
+			map[string]interface{}{"otp": "abcd", "pgp_key": "", "ttl": "1h0m0s"},
+		},
+	}
+	for _, tc := range cases {
+		status, err := client.Sys().GenerateRootInitWithOptions(tc.opts)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !status.Started {
+			t.Fatalf("bad: %#v", status)
+		}
+		if !reflect.DeepEqual(body, tc.expected) {
+			t.Fatalf("bad: %#v", body)
+		}
+	}
+}
@@ -1,11 +1,11 @@
 package command
 
 import (
-	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/api"
@@ -13,6 +13,17 @@ import (
 	"github.com/hashicorp/vault/helper/pgpkeys"
 	"github.com/hashicorp/vault/helper/xor"
 	"github.com/hashicorp/vault/meta"
+	"github.com/mattn/go-isatty"
+)
+
+const (
+	// generateRootNoTTYExitCode is the exit code returned when no key was
+	// provided and one cannot be prompted for because stdin is not a terminal.
+	generateRootNoTTYExitCode = 3
+
+	// generateRootSealedExitCode is the exit code returned when the Vault is
+	// sealed, since root generation requires an unsealed Vault.
+	generateRootSealedExitCode = 4
 )
 
 // GenerateRootCommand is a Command that generates a new root token.
@@ -25,38 +36,49 @@ type GenerateRootCommand struct {
 
 	// The nonce for the rekey request to send along
 	Nonce string
+
+	// format is the output format for the status
+	format string
 }
 
 func (c *GenerateRootCommand) Run(args []string) int {
-	var init, cancel, status, genotp bool
+	var init, reinit, cancel, status, genotp bool
 	var nonce, decode, otp, pgpKey string
 	var pgpKeyArr pgpkeys.PubKeyFilesFlag
+	var ttl time.Duration
 	flags := c.Meta.FlagSet("generate-root", meta.FlagSetDefault)
 	flags.BoolVar(&init, "init", false, "")
+	flags.BoolVar(&reinit, "reinit", false, "")
 	flags.BoolVar(&cancel, "cancel", false, "")
 	flags.BoolVar(&status, "status", false, "")
 	flags.BoolVar(&genotp, "genotp", false, "")
 	flags.StringVar(&decode, "decode", "", "")
 	flags.StringVar(&otp, "otp", "", "")
 	flags.StringVar(&nonce, "nonce", "", "")
+	flags.StringVar(&c.format, "format", "table", "")
+	flags.DurationVar(&ttl, "ttl", 0, "")
 	flags.Var(&pgpKeyArr, "pgp-key", "")
 	flags.Usage = func() { c.Ui.Error(c.Help()) }
 	if err := flags.Parse(args); err != nil {
 		return 1
 	}
 
+	switch strings.ToLower(c.format) {
+	case "table", "json", "yaml":
+	default:
+		c.Ui.Error(fmt.Sprintf("Invalid output format: %s", c.format))
+		return 1
+	}
+
+	// Print only the OTP so that it can be captured by the caller. This is
+	// done locally, so it does not need a working client configuration.
 	if genotp {
-		buf := make([]byte, 16)
-		readLen, err := rand.Read(buf)
+		otp, err := api.GenerateRootOTP()
 		if err != nil {
-			c.Ui.Error(fmt.Sprintf("Error reading random bytes: %s", err))
-			return 1
-		}
-		if readLen != 16 {
-			c.Ui.Error(fmt.Sprintf("Read %d bytes when we should have read 16", readLen))
+			c.Ui.Error(fmt.Sprintf("Error generating OTP: %s", err))
 			return 1
 		}
-		c.Ui.Output(fmt.Sprintf("OTP: %s", base64.StdEncoding.EncodeToString(buf)))
+		c.Ui.Output(otp)
 		return 0
 	}
 
@@ -77,6 +99,10 @@ func (c *GenerateRootCommand) Run(args []string) int {
 
 	// Check if the root generation is started
 	rootGenerationStatus, err := client.Sys().GenerateRootStatus()
+	if err == api.ErrGenerateRootSealed {
+		c.Ui.Error(err.Error())
+		return generateRootSealedExitCode
+	}
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error reading root generation status: %s", err))
 		return 1
@@ -86,7 +112,7 @@ func (c *GenerateRootCommand) Run(args []string) int {
 	// special function, check otp and pgpkey
 	checkOtpPgp := false
 	switch {
-	case init:
+	case init, reinit:
 		checkOtpPgp = true
 	case cancel:
 	case status:
@@ -129,19 +155,41 @@ func (c *GenerateRootCommand) Run(args []string) int {
 		c.Nonce = nonce
 	}
 
+	initOpts := &api.GenerateRootInitOptions{
+		OTP:    otp,
+		PGPKey: pgpKey,
+		TTL:    ttl,
+	}
+
 	// Check if we are running doing any restricted variants
 	switch {
 	case init:
-		return c.initGenerateRoot(client, otp, pgpKey)
+		return c.initGenerateRoot(client, initOpts)
+	case reinit:
+		return c.reinitGenerateRoot(client, initOpts)
 	case cancel:
 		return c.cancelGenerateRoot(client)
 	case status:
 		return c.rootGenerationStatus(client)
 	}
 
+	// Get the unseal key
+	args = flags.Args()
+	key := c.Key
+	if len(args) > 0 {
+		key = args[0]
+	}
+
+	// If we would have to prompt for the key but can't, fail before
+	// starting an attempt rather than surfacing a raw terminal error.
+	if key == "" && !isatty.IsTerminal(os.Stdin.Fd()) {
+		c.Ui.Error("No key provided and stdin is not a terminal; pass the key as an argument")
+		return generateRootNoTTYExitCode
+	}
+
 	// Start the root generation process if not started
 	if !rootGenerationStatus.Started {
-		rootGenerationStatus, err = client.Sys().GenerateRootInit(otp, pgpKey)
+		rootGenerationStatus, err = client.Sys().GenerateRootInitWithOptions(initOpts)
 		if err != nil {
 			c.Ui.Error(fmt.Sprintf("Error initializing root generation: %s", err))
 			return 1
@@ -151,12 +199,6 @@ func (c *GenerateRootCommand) Run(args []string) int {
 
 	serverNonce := rootGenerationStatus.Nonce
 
-	// Get the unseal key
-	args = flags.Args()
-	key := c.Key
-	if len(args) > 0 {
-		key = args[0]
-	}
 	if key == "" {
 		c.Nonce = serverNonce
 		fmt.Printf("Root generation operation nonce: %s\n", serverNonce)
@@ -197,7 +239,7 @@ func (c *GenerateRootCommand) verifyOTP(otp string) error {
 	if err != nil {
 		return fmt.Errorf("Error decoding base64 OTP value: %s", err)
 	}
-	if otpBytes == nil || len(otpBytes) != 16 {
+	if otpBytes == nil || len(otpBytes) != api.GenerateRootOTPLength {
 		return fmt.Errorf("Decoded OTP value is invalid or wrong length")
 	}
 
@@ -223,9 +265,9 @@ func (c *GenerateRootCommand) decode(encodedVal, otp string) int {
 }
 
 // initGenerateRoot is used to start the generation process
-func (c *GenerateRootCommand) initGenerateRoot(client *api.Client, otp string, pgpKey string) int {
+func (c *GenerateRootCommand) initGenerateRoot(client *api.Client, opts *api.GenerateRootInitOptions) int {
 	// Start the rekey
-	status, err := client.Sys().GenerateRootInit(otp, pgpKey)
+	status, err := client.Sys().GenerateRootInitWithOptions(opts)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error initializing root generation: %s", err))
 		return 1
@@ -236,6 +278,17 @@ func (c *GenerateRootCommand) initGenerateRoot(client *api.Client, otp string, p
 	return 0
 }
 
+// reinitGenerateRoot is used to throw away any in-progress attempt and
+// start the generation process afresh
+func (c *GenerateRootCommand) reinitGenerateRoot(client *api.Client, opts *api.GenerateRootInitOptions) int {
+	if err := client.Sys().GenerateRootCancel(); err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to cancel root generation: %s", err))
+		return 1
+	}
+
+	return c.initGenerateRoot(client, opts)
+}
+
 // cancelGenerateRoot is used to abort the generation process
 func (c *GenerateRootCommand) cancelGenerateRoot(client *api.Client) int {
 	err := client.Sys().GenerateRootCancel()
@@ -263,19 +316,37 @@ func (c *GenerateRootCommand) rootGenerationStatus(client *api.Client) int {
 
 // dumpStatus dumps the status to output
 func (c *GenerateRootCommand) dumpStatus(status *api.GenerateRootStatusResponse) {
+	if format := strings.ToLower(c.format); format != "" && format != "table" {
+		if err := Formatters[format].Output(c.Ui, nil, status); err != nil {
+			c.Ui.Error(fmt.Sprintf("Could not output status: %s", err))
+		}
+		return
+	}
+
 	// Dump the status
 	statString := fmt.Sprintf(
 		"Nonce: %s\n"+
 			"Started: %v\n"+
 			"Rekey Progress: %d\n"+
 			"Required Keys: %d\n"+
+			"Remaining Keys: %d\n"+
 			"Complete: %t",
 		status.Nonce,
 		status.Started,
 		status.Progress,
 		status.Required,
+		status.Remaining,
 		status.Complete,
 	)
+	if status.TTL > 0 {
+		statString = fmt.Sprintf("%s\nRoot Token TTL: %s", statString, time.Duration(status.TTL)*time.Second)
+	}
+	if len(status.StartedAt) > 0 {
+		statString = fmt.Sprintf("%s\nStarted At: %s", statString, status.StartedAt)
+	}
+	if len(status.ExpiresAt) > 0 {
+		statString = fmt.Sprintf("%s\nExpires At: %s", statString, status.ExpiresAt)
+	}
 	if len(status.PGPFingerprint) > 0 {
 		statString = fmt.Sprintf("%s\nPGP Fingerprint: %s", statString, status.PGPFingerprint)
 	}
@@ -321,6 +392,10 @@ Rekey Options:
   -init                   Initialize the root generation attempt. This can only
                           be done if no generation is already initiated.
 
+  -reinit                 Cancel any root generation attempt in progress and
+                          initialize a new one in a single step. The same
+                          options as '-init' must be provided.
+
   -cancel                 Reset the root generation process by throwing away
                           prior unseal keys and the configuration.
 
@@ -333,7 +408,8 @@ Rekey Options:
                           parameter.
 
   -genotp                 Returns a high-quality OTP suitable for passing into
-                          the '-init' method.
+                          the '-init' method. Only the OTP is printed, so that
+                          it can be captured by a script.
 
   -otp=abcd               The base64-encoded 16-byte OTP for use with the
                           '-init' or '-decode' methods.
@@ -344,6 +420,13 @@ Rekey Options:
                           encrypted and base64-encoded, in order, with the given
                           public key.
 
+  -ttl=1h                 The TTL of the generated root token, used with the
+                          '-init' method. It is limited to the maximum lease
+                          TTL. By default the token does not expire.
+
+  -format=table           The format for the status output. By default it is
+                          human-readable text. This can also be json or yaml.
+
   -nonce=abcd             The nonce provided at initialization time. This same
                           nonce value must be provided with each unseal key. If
                           the unseal key is not being passed in via the command
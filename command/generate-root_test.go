@@ -3,9 +3,11 @@ package command
 import (
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/helper/pgpkeys"
@@ -55,6 +57,110 @@ func TestGenerateRoot_Cancel(t *testing.T) {
 	}
 }
 
+func TestGenerateRoot_wrongKey(t *testing.T) {
+	core, _, _ := vault.TestCoreUnsealed(t)
+	ln, addr := http.TestServer(t, core)
+	defer ln.Close()
+
+	keyBytes, err := vault.GenerateRandBytes(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui := new(cli.MockUi)
+	c := &GenerateRootCommand{
+		Key: hex.EncodeToString(keyBytes),
+		Meta: meta.Meta{
+			Ui: ui,
+		},
+	}
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	// The server's reason for rejecting the key is shown
+	args := []string{"-address", addr, "-otp", otp}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "provided keys do not match the master key") {
+		t.Fatalf("bad: %s", ui.ErrorWriter.String())
+	}
+}
+
+func TestGenerateRoot_Reinit(t *testing.T) {
+	core, key, _ := vault.TestCoreUnsealed(t)
+	ln, addr := http.TestServer(t, core)
+	defer ln.Close()
+
+	ui := new(cli.MockUi)
+	c := &GenerateRootCommand{
+		Key: hex.EncodeToString(key),
+		Meta: meta.Meta{
+			Ui: ui,
+		},
+	}
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	args := []string{"-address", addr, "-init", "-otp", otp}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	config, err := core.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	oldNonce := config.Nonce
+
+	// A plain init over the in-progress attempt should still fail
+	if code := c.Run(args); code == 0 {
+		t.Fatal("expected init over an in-progress attempt to fail")
+	}
+
+	// Reinit over the in-progress attempt should start a fresh one
+	args = []string{"-address", addr, "-reinit", "-otp", otp}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	config, err = core.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if config == nil {
+		t.Fatal("should have a config for root generation")
+	}
+	if config.Nonce == oldNonce {
+		t.Fatal("expected a new nonce after reinit")
+	}
+
+	// Complete the attempt, then reinit over the completed attempt
+	c.Nonce = config.Nonce
+	if code := c.Run([]string{"-address", addr}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	config, err = core.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if config == nil {
+		t.Fatal("should have a config for root generation")
+	}
+}
+
 func TestGenerateRoot_status(t *testing.T) {
 	core, key, _ := vault.TestCoreUnsealed(t)
 	ln, addr := http.TestServer(t, core)
@@ -84,7 +190,233 @@ func TestGenerateRoot_status(t *testing.T) {
 		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
 	}
 
-	if !strings.Contains(string(ui.OutputWriter.Bytes()), "Started: true") {
+	output := ui.OutputWriter.String()
+	for _, expected := range []string{"Started: true", "Started At: ", "Expires At: "} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("bad: %s", output)
+		}
+	}
+}
+
+func TestGenerateRoot_TTL(t *testing.T) {
+	core, _, _ := vault.TestCoreUnsealed(t)
+	ln, addr := http.TestServer(t, core)
+	defer ln.Close()
+
+	ui := new(cli.MockUi)
+	c := &GenerateRootCommand{
+		Meta: meta.Meta{
+			Ui: ui,
+		},
+	}
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	args := []string{"-address", addr, "-init", "-otp", otp, "-ttl", "1h"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	config, err := core.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if config.TTL != time.Hour {
+		t.Fatalf("bad: %#v", config)
+	}
+	if !strings.Contains(ui.OutputWriter.String(), "Root Token TTL: 1h0m0s") {
+		t.Fatalf("bad: %s", ui.OutputWriter.String())
+	}
+}
+
+func TestGenerateRoot_statusJSON(t *testing.T) {
+	core, key, _ := vault.TestCoreUnsealed(t)
+	ln, addr := http.TestServer(t, core)
+	defer ln.Close()
+
+	ui := new(cli.MockUi)
+	c := &GenerateRootCommand{
+		Key: hex.EncodeToString(key),
+		Meta: meta.Meta{
+			Ui: ui,
+		},
+	}
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	args := []string{"-address", addr, "-init", "-otp", otp}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	ui.OutputWriter.Reset()
+	args = []string{"-address", addr, "-status", "-format", "json"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	var status map[string]interface{}
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &status); err != nil {
+		t.Fatalf("err: %s\n\n%s", err, ui.OutputWriter.String())
+	}
+
+	config, err := core.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if status["started"] != true ||
+		status["nonce"] != config.Nonce ||
+		status["required"] != float64(1) ||
+		status["encoded_root_token"] != "" ||
+		status["started_at"] != config.StartTime.Format(time.RFC3339) ||
+		status["expires_at"] != config.Expiration.Format(time.RFC3339) {
+		t.Fatalf("bad: %#v", status)
+	}
+
+	args = []string{"-address", addr, "-status", "-format", "bogus"}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+}
+
+func TestGenerateRoot_noTTY(t *testing.T) {
+	core, _, _ := vault.TestCoreUnsealed(t)
+	ln, addr := http.TestServer(t, core)
+	defer ln.Close()
+
+	ui := new(cli.MockUi)
+	c := &GenerateRootCommand{
+		Meta: meta.Meta{
+			Ui: ui,
+		},
+	}
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	// Stdin is not a terminal under test, so without a key we should
+	// fail fast rather than attempt to prompt
+	args := []string{"-address", addr, "-otp", otp}
+	if code := c.Run(args); code != generateRootNoTTYExitCode {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "stdin is not a terminal") {
+		t.Fatalf("bad: %s", ui.ErrorWriter.String())
+	}
+
+	// No attempt should have been started
+	config, err := core.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if config != nil {
+		t.Fatalf("bad: %#v", config)
+	}
+}
+
+func TestGenerateRoot_sealed(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := http.TestServer(t, core)
+	defer ln.Close()
+
+	if err := core.Seal(token); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := new(cli.MockUi)
+	c := &GenerateRootCommand{
+		Meta: meta.Meta{
+			Ui: ui,
+		},
+	}
+
+	args := []string{"-address", addr, "-status"}
+	if code := c.Run(args); code != generateRootSealedExitCode {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "Vault must be unsealed to use generate-root") {
+		t.Fatalf("bad: %s", ui.ErrorWriter.String())
+	}
+}
+
+func TestGenerateRoot_GenOTP_noClient(t *testing.T) {
+	ui := new(cli.MockUi)
+	c := &GenerateRootCommand{
+		Meta: meta.Meta{
+			Ui: ui,
+		},
+	}
+
+	// A broken client configuration must not prevent generating an OTP
+	args := []string{"-ca-cert", "/nonexistent/ca.pem", "-genotp"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	otpBytes, err := base64.StdEncoding.DecodeString(strings.TrimSpace(ui.OutputWriter.String()))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(otpBytes) != 16 {
+		t.Fatalf("bad: %d", len(otpBytes))
+	}
+}
+
+func TestGenerateRoot_GenOTP(t *testing.T) {
+	ui := new(cli.MockUi)
+	c := &GenerateRootCommand{
+		Meta: meta.Meta{
+			Ui: ui,
+		},
+	}
+
+	if code := c.Run([]string{"-genotp"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	otp := strings.TrimSpace(ui.OutputWriter.String())
+	otpBytes, err := base64.StdEncoding.DecodeString(otp)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(otpBytes) != 16 {
+		t.Fatalf("bad: %d", len(otpBytes))
+	}
+
+	// The OTP should round-trip a token through -decode
+	token, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenBytes, err := uuid.ParseUUID(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := xor.XORBase64(otp, base64.StdEncoding.EncodeToString(tokenBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ui.OutputWriter.Reset()
+	args := []string{
+		"-decode", base64.StdEncoding.EncodeToString(encoded),
+		"-otp", otp,
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if !strings.Contains(ui.OutputWriter.String(), "Root token: "+token) {
 		t.Fatalf("bad: %s", ui.OutputWriter.String())
 	}
 }
@@ -186,6 +186,11 @@ func (c *ServerCommand) Run(args []string) int {
 		DisableMlock:       config.DisableMlock,
 		MaxLeaseTTL:        config.MaxLeaseTTL,
 		DefaultLeaseTTL:    config.DefaultLeaseTTL,
+
+		GenerateRootStatusRequireAuth: config.GenerateRootStatusRequireAuth,
+		GenerateRootTimeout:           config.GenerateRootTimeout,
+		GenerateRootMaxFailures:       config.GenerateRootMaxFailures,
+		GenerateRootUpdatesPerMinute:  config.GenerateRootUpdatesPerMinute,
 	}
 
 	// Initialize the separate HA physical backend, if it exists
@@ -27,6 +27,12 @@ type Config struct {
 	DisableCache bool `hcl:"disable_cache"`
 	DisableMlock bool `hcl:"disable_mlock"`
 
+	GenerateRootStatusRequireAuth bool          `hcl:"generate_root_status_require_auth"`
+	GenerateRootTimeout           time.Duration `hcl:"-"`
+	GenerateRootTimeoutRaw        string        `hcl:"generate_root_timeout"`
+	GenerateRootMaxFailures       int           `hcl:"generate_root_max_failures"`
+	GenerateRootUpdatesPerMinute  int           `hcl:"generate_root_updates_per_minute"`
+
 	Telemetry *Telemetry `hcl:"telemetry"`
 
 	MaxLeaseTTL        time.Duration `hcl:"-"`
@@ -135,6 +141,11 @@ func (c *Config) Merge(c2 *Config) *Config {
 		result.DisableMlock = c2.DisableMlock
 	}
 
+	result.GenerateRootStatusRequireAuth = c.GenerateRootStatusRequireAuth
+	if c2.GenerateRootStatusRequireAuth {
+		result.GenerateRootStatusRequireAuth = c2.GenerateRootStatusRequireAuth
+	}
+
 	// merge these integers via a MAX operation
 	result.MaxLeaseTTL = c.MaxLeaseTTL
 	if c2.MaxLeaseTTL > result.MaxLeaseTTL {
@@ -146,6 +157,21 @@ func (c *Config) Merge(c2 *Config) *Config {
 		result.DefaultLeaseTTL = c2.DefaultLeaseTTL
 	}
 
+	result.GenerateRootTimeout = c.GenerateRootTimeout
+	if c2.GenerateRootTimeout > result.GenerateRootTimeout {
+		result.GenerateRootTimeout = c2.GenerateRootTimeout
+	}
+
+	result.GenerateRootMaxFailures = c.GenerateRootMaxFailures
+	if c2.GenerateRootMaxFailures != 0 {
+		result.GenerateRootMaxFailures = c2.GenerateRootMaxFailures
+	}
+
+	result.GenerateRootUpdatesPerMinute = c.GenerateRootUpdatesPerMinute
+	if c2.GenerateRootUpdatesPerMinute != 0 {
+		result.GenerateRootUpdatesPerMinute = c2.GenerateRootUpdatesPerMinute
+	}
+
 	return result
 }
 
@@ -197,6 +223,11 @@ func ParseConfig(d string) (*Config, error) {
 			return nil, err
 		}
 	}
+	if result.GenerateRootTimeoutRaw != "" {
+		if result.GenerateRootTimeout, err = time.ParseDuration(result.GenerateRootTimeoutRaw); err != nil {
+			return nil, err
+		}
+	}
 
 	list, ok := obj.Node.(*ast.ObjectList)
 	if !ok {
@@ -210,6 +241,10 @@ func ParseConfig(d string) (*Config, error) {
 		"listener",
 		"disable_cache",
 		"disable_mlock",
+		"generate_root_status_require_auth",
+		"generate_root_timeout",
+		"generate_root_max_failures",
+		"generate_root_updates_per_minute",
 		"telemetry",
 		"default_lease_ttl",
 		"max_lease_ttl",
@@ -179,6 +179,19 @@ func requestWrapTTL(r *http.Request, req *logical.Request) (*logical.Request, er
 }
 
 func respondError(w http.ResponseWriter, status int, err error) {
+	respondErrorWithCode(w, status, "", err)
+}
+
+// respondErrorWithCode is like respondError, but also includes a
+// machine-readable code in the response so that clients can tell errors
+// apart without matching on the message.
+func respondErrorWithCode(w http.ResponseWriter, status int, code string, err error) {
+	respondErrorResponse(w, status, err, &ErrorResponse{Code: code})
+}
+
+// respondErrorResponse is like respondErrorWithCode, but sends the given
+// error response, with the message of err added to its errors.
+func respondErrorResponse(w http.ResponseWriter, status int, err error, resp *ErrorResponse) {
 	// Adjust status code when sealed
 	if errwrap.Contains(err, vault.ErrSealed.Error()) {
 		status = http.StatusServiceUnavailable
@@ -192,7 +205,7 @@ func respondError(w http.ResponseWriter, status int, err error) {
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(status)
 
-	resp := &ErrorResponse{Errors: make([]string, 0, 1)}
+	resp.Errors = make([]string, 0, 1)
 	if err != nil {
 		resp.Errors = append(resp.Errors, err.Error())
 	}
@@ -254,4 +267,9 @@ func respondOk(w http.ResponseWriter, body interface{}) {
 
 type ErrorResponse struct {
 	Errors []string `json:"errors"`
+	Code   string   `json:"code,omitempty"`
+
+	// Nonce is set when an attempt to start a root generation is refused
+	// because another is in progress, so that the caller can join it
+	Nonce string `json:"nonce,omitempty"`
 }
@@ -4,8 +4,14 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/hashicorp/vault/helper/duration"
+	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/vault"
 )
 
@@ -13,12 +19,21 @@ func handleSysGenerateRootAttempt(core *vault.Core) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case "GET":
+			if err := core.GenerateRootStatusCheckToken(r.Header.Get(AuthHeaderName)); err != nil {
+				if err == logical.ErrPermissionDenied {
+					respondError(w, http.StatusForbidden, err)
+				} else {
+					respondError(w, http.StatusInternalServerError, err)
+				}
+				return
+			}
 			handleSysGenerateRootAttemptGet(core, w, r)
 		case "POST", "PUT":
 			handleSysGenerateRootAttemptPut(core, w, r)
 		case "DELETE":
 			handleSysGenerateRootAttemptDelete(core, w, r)
 		default:
+			w.Header().Set("Allow", "GET, POST, PUT, DELETE")
 			respondError(w, http.StatusMethodNotAllowed, nil)
 		}
 	})
@@ -62,15 +77,22 @@ func handleSysGenerateRootAttemptGet(core *vault.Core, w http.ResponseWriter, r
 
 	// Format the status
 	status := &GenerateRootStatusResponse{
-		Started:  false,
-		Progress: progress,
-		Required: sealConfig.SecretThreshold,
-		Complete: false,
+		Started:   false,
+		Progress:  progress,
+		Required:  sealConfig.SecretThreshold,
+		Remaining: generateRootRemaining(progress, sealConfig.SecretThreshold),
+		Complete:  false,
 	}
 	if generationConfig != nil {
 		status.Nonce = generationConfig.Nonce
 		status.Started = true
 		status.PGPFingerprint = generationConfig.PGPFingerprint
+		if expiresIn := generationConfig.Expiration.Sub(time.Now()); expiresIn > 0 {
+			status.ExpiresIn = int(expiresIn.Seconds())
+		}
+		status.TTL = int(generationConfig.TTL.Seconds())
+		status.StartedAt = generationConfig.StartTime.Format(time.RFC3339)
+		status.ExpiresAt = generationConfig.Expiration.Format(time.RFC3339)
 	}
 
 	respondOk(w, status)
@@ -89,10 +111,33 @@ func handleSysGenerateRootAttemptPut(core *vault.Core, w http.ResponseWriter, r
 		return
 	}
 
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		ttl, err = duration.ParseDurationSecond(req.TTL)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("error parsing \"ttl\": %s", err))
+			return
+		}
+	}
+
 	// Attemptialize the generation
-	err := core.GenerateRootInit(req.OTP, req.PGPKey)
+	token := r.Header.Get(AuthHeaderName)
+	err := core.GenerateRootInitWithTTL(token, req.OTP, req.PGPKey, ttl)
 	if err != nil {
-		respondError(w, http.StatusBadRequest, err)
+		if inProgress, ok := err.(*vault.ErrGenerateRootInProgress); ok {
+			// Only reveal the nonce of the running attempt to callers that
+			// may read the status
+			resp := &ErrorResponse{Code: generateRootErrorCode(err)}
+			if core.GenerateRootStatusCheckToken(token) == nil {
+				resp.Nonce = inProgress.Nonce
+			} else {
+				err = &vault.ErrGenerateRootInProgress{}
+			}
+			respondErrorResponse(w, http.StatusTooManyRequests, err, resp)
+		} else {
+			respondErrorWithCode(w, http.StatusBadRequest, generateRootErrorCode(err), err)
+		}
 		return
 	}
 
@@ -100,7 +145,7 @@ func handleSysGenerateRootAttemptPut(core *vault.Core, w http.ResponseWriter, r
 }
 
 func handleSysGenerateRootAttemptDelete(core *vault.Core, w http.ResponseWriter, r *http.Request) {
-	err := core.GenerateRootCancel()
+	err := core.GenerateRootCancel(r.Header.Get(AuthHeaderName))
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, err)
 		return
@@ -110,6 +155,28 @@ func handleSysGenerateRootAttemptDelete(core *vault.Core, w http.ResponseWriter,
 
 func handleSysGenerateRootUpdate(core *vault.Core) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST", "PUT":
+		default:
+			w.Header().Set("Allow", "POST, PUT")
+			respondError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		// Limit updates from each client token, or each remote address for
+		// requests without a valid one
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if retryAfter := core.GenerateRootRateLimit(r.Header.Get(AuthHeaderName), host); retryAfter > 0 {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			respondErrorWithCode(w, http.StatusTooManyRequests, "rate_limited", fmt.Errorf(
+				"too many root generation updates; retry after %d seconds", seconds))
+			return
+		}
+
 		// Parse the request
 		var req GenerateRootUpdateRequest
 		if err := parseRequest(r, &req); err != nil {
@@ -133,29 +200,66 @@ func handleSysGenerateRootUpdate(core *vault.Core) http.Handler {
 		}
 
 		// Use the key to make progress on root generation
-		result, err := core.GenerateRootUpdate(key, req.Nonce)
+		result, err := core.GenerateRootUpdate(r.Header.Get(AuthHeaderName), key, req.Nonce)
 		if err != nil {
-			respondError(w, http.StatusBadRequest, err)
+			respondErrorWithCode(w, http.StatusBadRequest, generateRootErrorCode(err), err)
 			return
 		}
 
 		resp := &GenerateRootStatusResponse{
 			Complete:         result.Progress == result.Required,
-			Nonce:            req.Nonce,
+			Nonce:            result.Nonce,
 			Progress:         result.Progress,
 			Required:         result.Required,
+			Remaining:        generateRootRemaining(result.Progress, result.Required),
 			Started:          true,
 			EncodedRootToken: result.EncodedRootToken,
 			PGPFingerprint:   result.PGPFingerprint,
+			TTL:              int(result.TTL.Seconds()),
 		}
 
 		respondOk(w, resp)
 	})
 }
 
+// generateRootErrorCode returns the machine-readable code reported to
+// clients for a root generation error, or an empty string if the error has
+// no specific code.
+func generateRootErrorCode(err error) string {
+	switch err.(type) {
+	case *vault.ErrGenerateRootInProgress:
+		return "in_progress"
+	case *vault.ErrInvalidKey:
+		return "invalid_key"
+	case *vault.ErrGenerateRootCanceled:
+		return "canceled"
+	case *vault.ErrGenerateRootLockedOut:
+		return "locked_out"
+	}
+	switch err {
+	case vault.ErrGenerateRootNotStarted:
+		return "not_started"
+	case vault.ErrGenerateRootNonceRequired:
+		return "nonce_required"
+	case vault.ErrGenerateRootNonceMismatch:
+		return "nonce_mismatch"
+	}
+	return ""
+}
+
+// generateRootRemaining returns the number of keys still needed to
+// complete a root generation attempt, floored at zero.
+func generateRootRemaining(progress, required int) int {
+	if progress >= required {
+		return 0
+	}
+	return required - progress
+}
+
 type GenerateRootInitRequest struct {
 	OTP    string `json:"otp"`
 	PGPKey string `json:"pgp_key"`
+	TTL    string `json:"ttl"`
 }
 
 type GenerateRootStatusResponse struct {
@@ -163,9 +267,14 @@ type GenerateRootStatusResponse struct {
 	Started          bool   `json:"started"`
 	Progress         int    `json:"progress"`
 	Required         int    `json:"required"`
+	Remaining        int    `json:"remaining"`
 	Complete         bool   `json:"complete"`
 	EncodedRootToken string `json:"encoded_root_token"`
 	PGPFingerprint   string `json:"pgp_fingerprint"`
+	ExpiresIn        int    `json:"expires_in"`
+	StartedAt        string `json:"started_at"`
+	ExpiresAt        string `json:"expires_at"`
+	TTL              int    `json:"ttl"`
 }
 
 type GenerateRootUpdateRequest struct {
@@ -0,0 +1,70 @@
+// +build vault
+
+package http
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/xor"
+	"github.com/hashicorp/vault/vault"
+)
+
+func TestSysGenerateRoot_RecoveryKeys(t *testing.T) {
+	bc, rc := vault.TestSealDefConfigs()
+	rc.SecretShares = 5
+	rc.SecretThreshold = 2
+	core, _, recoveryKeys, token := vault.TestCoreUnsealedWithConfigs(t, bc, rc)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	resp := testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": otp,
+	})
+	var rootGenerationStatus map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+	nonce := rootGenerationStatus["nonce"].(string)
+
+	// The required number of keys comes from the recovery configuration
+	if rootGenerationStatus["required"] != float64(2) {
+		t.Fatalf("bad: %#v", rootGenerationStatus)
+	}
+
+	for i, key := range recoveryKeys[:2] {
+		resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+			"nonce": nonce,
+			"key":   hex.EncodeToString(key),
+		})
+		rootGenerationStatus = nil
+		testResponseStatus(t, resp, 200)
+		testResponseBody(t, resp, &rootGenerationStatus)
+		if rootGenerationStatus["progress"] != float64(i+1) || rootGenerationStatus["required"] != float64(2) {
+			t.Fatalf("bad: %#v", rootGenerationStatus)
+		}
+	}
+	if rootGenerationStatus["complete"] != true {
+		t.Fatalf("bad: %#v", rootGenerationStatus)
+	}
+
+	tokenBytes, err := xor.XORBase64(rootGenerationStatus["encoded_root_token"].(string), otp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newRootToken, err := uuid.FormatUUID(tokenBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp = testHttpGet(t, newRootToken, addr+"/v1/auth/token/lookup-self")
+	testResponseStatus(t, resp, 200)
+}
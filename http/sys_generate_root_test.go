@@ -5,7 +5,10 @@ import (
 	"encoding/hex"
 	"net/http"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/helper/pgpkeys"
@@ -29,9 +32,14 @@ func TestSysGenerateRootAttempt_Status(t *testing.T) {
 		"started":            false,
 		"progress":           float64(0),
 		"required":           float64(1),
+		"remaining":          float64(1),
 		"complete":           false,
 		"encoded_root_token": "",
 		"pgp_fingerprint":    "",
+		"expires_in":         float64(0),
+		"ttl":                float64(0),
+		"started_at":         "",
+		"expires_at":         "",
 		"nonce":              "",
 	}
 	testResponseStatus(t, resp, 200)
@@ -41,6 +49,114 @@ func TestSysGenerateRootAttempt_Status(t *testing.T) {
 	}
 }
 
+func TestSysGenerateRootAttempt_Status_RequireAuth(t *testing.T) {
+	// By default the status can be read without a token
+	core, _, _ := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	resp := testHttpGet(t, "", addr+"/v1/sys/generate-root/attempt")
+	testResponseStatus(t, resp, 200)
+
+	core, _, token := vault.TestCoreUnsealedWithConfig(t, &vault.CoreConfig{
+		GenerateRootStatusRequireAuth: true,
+	})
+	ln, addr = TestServer(t, core)
+	defer ln.Close()
+
+	resp = testHttpGet(t, "", addr+"/v1/sys/generate-root/attempt")
+	testResponseStatus(t, resp, 403)
+
+	resp = testHttpGet(t, "foobar", addr+"/v1/sys/generate-root/attempt")
+	testResponseStatus(t, resp, 403)
+
+	resp = testHttpGet(t, token, addr+"/v1/sys/generate-root/attempt")
+	testResponseStatus(t, resp, 200)
+
+	// Starting a second attempt only reveals the nonce of the running one
+	// to callers that may read the status
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+	resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": otp,
+	})
+	var rootGenerationStatus map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+	nonce := rootGenerationStatus["nonce"].(string)
+
+	for _, tc := range []struct {
+		token  string
+		reveal bool
+	}{
+		{"", false},
+		{"foobar", false},
+		{token, true},
+	} {
+		resp = testHttpPut(t, tc.token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+			"otp": otp,
+		})
+		var actual map[string]interface{}
+		testResponseStatus(t, resp, 429)
+		testResponseBody(t, resp, &actual)
+		if actual["code"] != "in_progress" {
+			t.Fatalf("bad: %#v", actual)
+		}
+		if _, ok := actual["nonce"]; ok != tc.reveal {
+			t.Fatalf("bad: %q: %#v", tc.token, actual)
+		}
+		if tc.reveal && actual["nonce"] != nonce {
+			t.Fatalf("bad: %q: %#v", tc.token, actual)
+		}
+		errs := actual["errors"].([]interface{})
+		if !tc.reveal && (len(errs) != 1 || strings.Contains(errs[0].(string), nonce)) {
+			t.Fatalf("bad: %q: %#v", tc.token, actual)
+		}
+	}
+}
+
+func TestSysGenerateRoot_MethodNotAllowed(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	cases := []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{"PATCH", "/v1/sys/generate-root/attempt", "GET, POST, PUT, DELETE"},
+		{"GET", "/v1/sys/generate-root/update", "POST, PUT"},
+		{"DELETE", "/v1/sys/generate-root/update", "POST, PUT"},
+	}
+	for _, tc := range cases {
+		req, err := http.NewRequest(tc.method, addr+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(AuthHeaderName, token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		testResponseStatus(t, resp, 405)
+		if allow := resp.Header.Get("Allow"); allow != tc.allow {
+			t.Fatalf("%s %s: bad: %q", tc.method, tc.path, allow)
+		}
+	}
+
+	// Supported methods are not given an Allow header
+	resp := testHttpGet(t, token, addr+"/v1/sys/generate-root/attempt")
+	testResponseStatus(t, resp, 200)
+	if allow := resp.Header.Get("Allow"); allow != "" {
+		t.Fatalf("bad: %q", allow)
+	}
+}
+
 func TestSysGenerateRootAttempt_Setup_OTP(t *testing.T) {
 	core, _, token := vault.TestCoreUnsealed(t)
 	ln, addr := TestServer(t, core)
@@ -63,9 +179,14 @@ func TestSysGenerateRootAttempt_Setup_OTP(t *testing.T) {
 		"started":            true,
 		"progress":           float64(0),
 		"required":           float64(1),
+		"remaining":          float64(1),
 		"complete":           false,
 		"encoded_root_token": "",
 		"pgp_fingerprint":    "",
+		"expires_in":         float64(0),
+		"ttl":                float64(0),
+		"started_at":         "",
+		"expires_at":         "",
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
@@ -73,6 +194,16 @@ func TestSysGenerateRootAttempt_Setup_OTP(t *testing.T) {
 		t.Fatalf("nonce was empty")
 	}
 	expected["nonce"] = actual["nonce"]
+	if actual["expires_in"].(float64) <= 0 {
+		t.Fatalf("expires_in was not set")
+	}
+	expected["expires_in"] = actual["expires_in"]
+	for _, field := range []string{"started_at", "expires_at"} {
+		if _, err := time.Parse(time.RFC3339, actual[field].(string)); err != nil {
+			t.Fatalf("bad %s: %v", field, err)
+		}
+		expected[field] = actual[field]
+	}
 	if !reflect.DeepEqual(actual, expected) {
 		t.Fatalf("\nexpected: %#v\nactual: %#v", expected, actual)
 	}
@@ -84,9 +215,14 @@ func TestSysGenerateRootAttempt_Setup_OTP(t *testing.T) {
 		"started":            true,
 		"progress":           float64(0),
 		"required":           float64(1),
+		"remaining":          float64(1),
 		"complete":           false,
 		"encoded_root_token": "",
 		"pgp_fingerprint":    "",
+		"expires_in":         float64(0),
+		"ttl":                float64(0),
+		"started_at":         "",
+		"expires_at":         "",
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
@@ -94,6 +230,16 @@ func TestSysGenerateRootAttempt_Setup_OTP(t *testing.T) {
 		t.Fatalf("nonce was empty")
 	}
 	expected["nonce"] = actual["nonce"]
+	if actual["expires_in"].(float64) <= 0 {
+		t.Fatalf("expires_in was not set")
+	}
+	expected["expires_in"] = actual["expires_in"]
+	for _, field := range []string{"started_at", "expires_at"} {
+		if _, err := time.Parse(time.RFC3339, actual[field].(string)); err != nil {
+			t.Fatalf("bad %s: %v", field, err)
+		}
+		expected[field] = actual[field]
+	}
 	if !reflect.DeepEqual(actual, expected) {
 		t.Fatalf("\nexpected: %#v\nactual: %#v", expected, actual)
 	}
@@ -117,9 +263,14 @@ func TestSysGenerateRootAttempt_Setup_PGP(t *testing.T) {
 		"started":            true,
 		"progress":           float64(0),
 		"required":           float64(1),
+		"remaining":          float64(1),
 		"complete":           false,
 		"encoded_root_token": "",
 		"pgp_fingerprint":    "816938b8a29146fbe245dd29e7cbaf8e011db793",
+		"expires_in":         float64(0),
+		"ttl":                float64(0),
+		"started_at":         "",
+		"expires_at":         "",
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
@@ -127,11 +278,118 @@ func TestSysGenerateRootAttempt_Setup_PGP(t *testing.T) {
 		t.Fatalf("nonce was empty")
 	}
 	expected["nonce"] = actual["nonce"]
+	if actual["expires_in"].(float64) <= 0 {
+		t.Fatalf("expires_in was not set")
+	}
+	expected["expires_in"] = actual["expires_in"]
+	for _, field := range []string{"started_at", "expires_at"} {
+		if _, err := time.Parse(time.RFC3339, actual[field].(string)); err != nil {
+			t.Fatalf("bad %s: %v", field, err)
+		}
+		expected[field] = actual[field]
+	}
 	if !reflect.DeepEqual(actual, expected) {
 		t.Fatalf("\nexpected: %#v\nactual: %#v", expected, actual)
 	}
 }
 
+func TestSysGenerateRootAttempt_Setup_TTL(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp := testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"pgp_key": pgpkeys.TestPubKey1,
+		"ttl":     "bogus",
+	})
+	testResponseStatus(t, resp, 400)
+
+	resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"pgp_key": pgpkeys.TestPubKey1,
+		"ttl":     "1h",
+	})
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if actual["ttl"] != float64(3600) {
+		t.Fatalf("bad: %#v", actual)
+	}
+	if actual["pgp_fingerprint"] != "816938b8a29146fbe245dd29e7cbaf8e011db793" {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	resp = testHttpGet(t, token, addr+"/v1/sys/generate-root/attempt")
+	actual = nil
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if actual["ttl"] != float64(3600) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestSysGenerateRootAttempt_Setup_TTL_max(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealedWithConfig(t, &vault.CoreConfig{
+		DefaultLeaseTTL: time.Hour,
+		MaxLeaseTTL:     2 * time.Hour,
+	})
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	// The status reports the TTL limited to the maximum lease TTL
+	resp := testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"pgp_key": pgpkeys.TestPubKey1,
+		"ttl":     "100000h",
+	})
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if actual["ttl"] != float64(7200) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestSysGenerateRootAttempt_Setup_InProgress(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	resp := testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": otp,
+	})
+	var rootGenerationStatus map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+	nonce := rootGenerationStatus["nonce"].(string)
+
+	resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"pgp_key": pgpkeys.TestPubKey1,
+	})
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 429)
+	testResponseBody(t, resp, &actual)
+	if actual["code"] != "in_progress" || actual["nonce"] != nonce {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	// The running attempt must be left untouched
+	resp = testHttpGet(t, token, addr+"/v1/sys/generate-root/attempt")
+	rootGenerationStatus = nil
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+	if rootGenerationStatus["nonce"] != nonce || rootGenerationStatus["pgp_fingerprint"] != "" {
+		t.Fatalf("bad: %#v", rootGenerationStatus)
+	}
+}
+
 func TestSysGenerateRootAttempt_Cancel(t *testing.T) {
 	core, _, token := vault.TestCoreUnsealed(t)
 	ln, addr := TestServer(t, core)
@@ -153,9 +411,14 @@ func TestSysGenerateRootAttempt_Cancel(t *testing.T) {
 		"started":            true,
 		"progress":           float64(0),
 		"required":           float64(1),
+		"remaining":          float64(1),
 		"complete":           false,
 		"encoded_root_token": "",
 		"pgp_fingerprint":    "",
+		"expires_in":         float64(0),
+		"ttl":                float64(0),
+		"started_at":         "",
+		"expires_at":         "",
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
@@ -163,6 +426,16 @@ func TestSysGenerateRootAttempt_Cancel(t *testing.T) {
 		t.Fatalf("nonce was empty")
 	}
 	expected["nonce"] = actual["nonce"]
+	if actual["expires_in"].(float64) <= 0 {
+		t.Fatalf("expires_in was not set")
+	}
+	expected["expires_in"] = actual["expires_in"]
+	for _, field := range []string{"started_at", "expires_at"} {
+		if _, err := time.Parse(time.RFC3339, actual[field].(string)); err != nil {
+			t.Fatalf("bad %s: %v", field, err)
+		}
+		expected[field] = actual[field]
+	}
 	if !reflect.DeepEqual(actual, expected) {
 		t.Fatalf("\nexpected: %#v\nactual: %#v", expected, actual)
 	}
@@ -180,9 +453,14 @@ func TestSysGenerateRootAttempt_Cancel(t *testing.T) {
 		"started":            false,
 		"progress":           float64(0),
 		"required":           float64(1),
+		"remaining":          float64(1),
 		"complete":           false,
 		"encoded_root_token": "",
 		"pgp_fingerprint":    "",
+		"expires_in":         float64(0),
+		"ttl":                float64(0),
+		"started_at":         "",
+		"expires_at":         "",
 		"nonce":              "",
 	}
 	testResponseStatus(t, resp, 200)
@@ -211,6 +489,534 @@ func TestSysGenerateRoot_badKey(t *testing.T) {
 	testResponseStatus(t, resp, 400)
 }
 
+func TestSysGenerateRoot_wrongKey(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	resp := testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": otp,
+	})
+	var rootGenerationStatus map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+
+	keyBytes, err := vault.GenerateRandBytes(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+		"nonce": rootGenerationStatus["nonce"].(string),
+		"key":   hex.EncodeToString(keyBytes),
+	})
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 400)
+	testResponseBody(t, resp, &actual)
+	errs, ok := actual["errors"].([]interface{})
+	if !ok || len(errs) != 1 || !strings.HasPrefix(errs[0].(string), "invalid key") {
+		t.Fatalf("bad: %#v", actual)
+	}
+	if actual["code"] != "invalid_key" {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	resp = testHttpGet(t, token, addr+"/v1/sys/generate-root/attempt")
+	rootGenerationStatus = nil
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+	if rootGenerationStatus["progress"] != float64(0) {
+		t.Fatalf("bad: %#v", rootGenerationStatus)
+	}
+	if rootGenerationStatus["encoded_root_token"] != "" {
+		t.Fatalf("bad: %#v", rootGenerationStatus)
+	}
+}
+
+func TestSysGenerateRoot_wrongKey_threshold(t *testing.T) {
+	core, _, token := testGenerateRootThresholdCore(t, nil)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": base64.StdEncoding.EncodeToString(otpBytes),
+	})
+	var rootGenerationStatus map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+	nonce := rootGenerationStatus["nonce"].(string)
+
+	// A share cannot be verified on its own, so a bogus one makes progress
+	keyBytes, err := vault.GenerateRandBytes(33)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+		"nonce": nonce,
+		"key":   hex.EncodeToString(keyBytes),
+	})
+	rootGenerationStatus = nil
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+	if rootGenerationStatus["progress"] != float64(1) {
+		t.Fatalf("bad: %#v", rootGenerationStatus)
+	}
+
+	// Shares of different lengths cannot be combined
+	keyBytes, err = vault.GenerateRandBytes(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+		"nonce": nonce,
+		"key":   hex.EncodeToString(keyBytes),
+	})
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 400)
+	testResponseBody(t, resp, &actual)
+	if actual["code"] != "invalid_key" {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	resp = testHttpGet(t, token, addr+"/v1/sys/generate-root/attempt")
+	rootGenerationStatus = nil
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+	if rootGenerationStatus["progress"] != float64(0) {
+		t.Fatalf("bad: %#v", rootGenerationStatus)
+	}
+}
+
+func TestSysGenerateRoot_lockout(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealedWithConfig(t, &vault.CoreConfig{
+		GenerateRootMaxFailures: 3,
+	})
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	resp := testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": otp,
+	})
+	var rootGenerationStatus map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+	nonce := rootGenerationStatus["nonce"].(string)
+
+	for i := 0; i < 3; i++ {
+		keyBytes, err := vault.GenerateRandBytes(32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+			"nonce": nonce,
+			"key":   hex.EncodeToString(keyBytes),
+		})
+		var actual map[string]interface{}
+		testResponseStatus(t, resp, 400)
+		testResponseBody(t, resp, &actual)
+		code := "invalid_key"
+		if i == 2 {
+			code = "canceled"
+		}
+		if actual["code"] != code {
+			t.Fatalf("bad: after %d keys: %#v", i+1, actual)
+		}
+
+		resp = testHttpGet(t, token, addr+"/v1/sys/generate-root/attempt")
+		rootGenerationStatus = nil
+		testResponseStatus(t, resp, 200)
+		testResponseBody(t, resp, &rootGenerationStatus)
+		if started := rootGenerationStatus["started"].(bool); started != (i < 2) {
+			t.Fatalf("bad: after %d keys: %#v", i+1, rootGenerationStatus)
+		}
+	}
+
+	// A new attempt is refused during the lockout
+	resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": otp,
+	})
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 400)
+	testResponseBody(t, resp, &actual)
+	if actual["code"] != "locked_out" {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestSysGenerateRoot_lockout_cancel(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealedWithConfig(t, &vault.CoreConfig{
+		GenerateRootMaxFailures: 3,
+	})
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	startAttempt := func() string {
+		resp := testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+			"otp": otp,
+		})
+		var rootGenerationStatus map[string]interface{}
+		testResponseStatus(t, resp, 200)
+		testResponseBody(t, resp, &rootGenerationStatus)
+		return rootGenerationStatus["nonce"].(string)
+	}
+	badKey := func(nonce string) {
+		keyBytes, err := vault.GenerateRandBytes(32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := testHttpPut(t, token, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+			"nonce": nonce,
+			"key":   hex.EncodeToString(keyBytes),
+		})
+		testResponseStatus(t, resp, 400)
+	}
+
+	nonce := startAttempt()
+	badKey(nonce)
+	badKey(nonce)
+
+	// Canceling and starting over must not clear the failure count
+	resp := testHttpDelete(t, token, addr+"/v1/sys/generate-root/attempt")
+	testResponseStatus(t, resp, 204)
+	badKey(startAttempt())
+
+	resp = testHttpGet(t, token, addr+"/v1/sys/generate-root/attempt")
+	var rootGenerationStatus map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+	if rootGenerationStatus["started"] != false {
+		t.Fatalf("bad: %#v", rootGenerationStatus)
+	}
+
+	resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": otp,
+	})
+	testResponseStatus(t, resp, 400)
+}
+
+func TestSysGenerateRoot_lockout_threshold(t *testing.T) {
+	core, _, token := testGenerateRootThresholdCore(t, &vault.CoreConfig{
+		GenerateRootMaxFailures: 3,
+	})
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	resp := testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": otp,
+	})
+	var rootGenerationStatus map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+	nonce := rootGenerationStatus["nonce"].(string)
+
+	// Each bogus share is accepted until the threshold is reached, but the
+	// accepted shares must not reset the failure count
+	for i := 0; i < 3; i++ {
+		for j, status := range []int{200, 400} {
+			keyBytes, err := vault.GenerateRandBytes(33)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+				"nonce": nonce,
+				"key":   hex.EncodeToString(keyBytes),
+			})
+			testResponseStatus(t, resp, status)
+			if j == 0 {
+				continue
+			}
+
+			resp = testHttpGet(t, token, addr+"/v1/sys/generate-root/attempt")
+			rootGenerationStatus = nil
+			testResponseStatus(t, resp, 200)
+			testResponseBody(t, resp, &rootGenerationStatus)
+			if started := rootGenerationStatus["started"].(bool); started != (i < 2) {
+				t.Fatalf("bad: after %d attempts: %#v", i+1, rootGenerationStatus)
+			}
+		}
+	}
+
+	// A new attempt is refused during the lockout
+	resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": otp,
+	})
+	testResponseStatus(t, resp, 400)
+}
+
+func TestSysGenerateRoot_rateLimit(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealedWithConfig(t, &vault.CoreConfig{
+		GenerateRootUpdatesPerMinute: 2,
+	})
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	startAttempt := func() {
+		resp := testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+			"otp": otp,
+		})
+		testResponseStatus(t, resp, 200)
+	}
+	update := func(token string) *http.Response {
+		return testHttpPut(t, token, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+			"nonce": "abcd",
+			"key":   "0123",
+		})
+	}
+
+	// Updates are not limited without an attempt in progress
+	for i := 0; i < 3; i++ {
+		testResponseStatus(t, update(token), 400)
+	}
+
+	startAttempt()
+	for _, source := range []string{token, ""} {
+		testResponseStatus(t, update(source), 400)
+		testResponseStatus(t, update(source), 400)
+
+		resp := update(source)
+		testResponseStatus(t, resp, 429)
+		retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil || retryAfter <= 0 || retryAfter > 30 {
+			t.Fatalf("bad: %q", resp.Header.Get("Retry-After"))
+		}
+	}
+
+	// Invalid tokens are limited by remote address, so a new token on each
+	// request does not get a new limit
+	for i := 0; i < 3; i++ {
+		bogus, err := uuid.GenerateUUID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		testResponseStatus(t, update(bogus), 429)
+	}
+
+	// Canceling the attempt resets the limit
+	resp := testHttpDelete(t, token, addr+"/v1/sys/generate-root/attempt")
+	testResponseStatus(t, resp, 204)
+	startAttempt()
+	testResponseStatus(t, update(token), 400)
+}
+
+// testGenerateRootThresholdCore returns an unsealed core whose master key
+// is split into three shares with a threshold of two, along with the
+// shares and the root token.
+func testGenerateRootThresholdCore(t *testing.T, conf *vault.CoreConfig) (*vault.Core, [][]byte, string) {
+	if conf == nil {
+		conf = &vault.CoreConfig{}
+	}
+	core := vault.TestCoreWithConfig(t, conf)
+	result, err := core.Initialize(&vault.SealConfig{
+		SecretShares:    3,
+		SecretThreshold: 2,
+	}, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, key := range result.SecretShares[:2] {
+		if _, err := core.Unseal(vault.TestKeyCopy(key)); err != nil {
+			t.Fatalf("unseal err: %s", err)
+		}
+	}
+	return core, result.SecretShares, result.RootToken
+}
+
+func TestSysGenerateRoot_duplicateKey(t *testing.T) {
+	core, shares, token := testGenerateRootThresholdCore(t, nil)
+
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	resp := testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": otp,
+	})
+	var rootGenerationStatus map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+	nonce := rootGenerationStatus["nonce"].(string)
+
+	key := hex.EncodeToString(shares[0])
+	resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+		"nonce": nonce,
+		"key":   key,
+	})
+	testResponseStatus(t, resp, 200)
+
+	// Resubmitting the key, even more times than the failure limit, neither
+	// makes progress nor cancels the attempt
+	for i := 0; i < 6; i++ {
+		resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+			"nonce": nonce,
+			"key":   key,
+		})
+		testResponseStatus(t, resp, 400)
+	}
+
+	resp = testHttpGet(t, token, addr+"/v1/sys/generate-root/attempt")
+	rootGenerationStatus = nil
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+	if rootGenerationStatus["progress"] != float64(1) {
+		t.Fatalf("bad: %#v", rootGenerationStatus)
+	}
+	if rootGenerationStatus["complete"] != false {
+		t.Fatalf("bad: %#v", rootGenerationStatus)
+	}
+}
+
+func TestSysGenerateRoot_errorCodes(t *testing.T) {
+	core, master, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp := testHttpPut(t, token, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+		"nonce": "abcd",
+		"key":   hex.EncodeToString(master),
+	})
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 400)
+	testResponseBody(t, resp, &actual)
+	if actual["code"] != "not_started" {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": base64.StdEncoding.EncodeToString(otpBytes),
+	})
+	testResponseStatus(t, resp, 200)
+
+	resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+		"nonce": "abcd",
+		"key":   hex.EncodeToString(master),
+	})
+	actual = nil
+	testResponseStatus(t, resp, 400)
+	testResponseBody(t, resp, &actual)
+	if actual["code"] != "nonce_mismatch" {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	// Errors without a specific code leave it out
+	resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+		"nonce": "abcd",
+		"key":   "zz",
+	})
+	actual = nil
+	testResponseStatus(t, resp, 400)
+	testResponseBody(t, resp, &actual)
+	if _, ok := actual["code"]; ok {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestSysGenerateRoot_nonce(t *testing.T) {
+	core, master, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	otpBytes, err := vault.GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := testHttpPut(t, token, addr+"/v1/sys/generate-root/attempt", map[string]interface{}{
+		"otp": base64.StdEncoding.EncodeToString(otpBytes),
+	})
+	var rootGenerationStatus map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+	nonce := rootGenerationStatus["nonce"].(string)
+
+	cases := []struct {
+		nonce string
+		code  string
+	}{
+		{"", "nonce_required"},
+		{"abcd", "nonce_mismatch"},
+	}
+	for _, tc := range cases {
+		resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+			"nonce": tc.nonce,
+			"key":   hex.EncodeToString(master),
+		})
+		var actual map[string]interface{}
+		testResponseStatus(t, resp, 400)
+		testResponseBody(t, resp, &actual)
+		if actual["code"] != tc.code {
+			t.Fatalf("bad: %#v", actual)
+		}
+
+		// The error must not reveal the nonce of the attempt
+		for _, e := range actual["errors"].([]interface{}) {
+			if strings.Contains(e.(string), nonce) {
+				t.Fatalf("nonce leaked: %#v", actual)
+			}
+		}
+	}
+
+	resp = testHttpPut(t, token, addr+"/v1/sys/generate-root/update", map[string]interface{}{
+		"nonce": nonce,
+		"key":   hex.EncodeToString(master),
+	})
+	rootGenerationStatus = nil
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &rootGenerationStatus)
+	if rootGenerationStatus["nonce"] != nonce || rootGenerationStatus["complete"] != true {
+		t.Fatalf("bad: %#v", rootGenerationStatus)
+	}
+}
+
 func TestSysGenerateRoot_ReAttemptUpdate(t *testing.T) {
 	core, _, token := vault.TestCoreUnsealed(t)
 	ln, addr := TestServer(t, core)
@@ -267,8 +1073,13 @@ func TestSysGenerateRoot_Update_OTP(t *testing.T) {
 		"nonce":           rootGenerationStatus["nonce"].(string),
 		"progress":        float64(1),
 		"required":        float64(1),
+		"remaining":       float64(0),
 		"started":         true,
 		"pgp_fingerprint": "",
+		"expires_in":      float64(0),
+		"ttl":             float64(0),
+		"started_at":      "",
+		"expires_at":      "",
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
@@ -349,8 +1160,13 @@ func TestSysGenerateRoot_Update_PGP(t *testing.T) {
 		"nonce":           rootGenerationStatus["nonce"].(string),
 		"progress":        float64(1),
 		"required":        float64(1),
+		"remaining":       float64(0),
 		"started":         true,
 		"pgp_fingerprint": "816938b8a29146fbe245dd29e7cbaf8e011db793",
+		"expires_in":      float64(0),
+		"ttl":             float64(0),
+		"started_at":      "",
+		"expires_at":      "",
 	}
 	testResponseStatus(t, resp, 200)
 	testResponseBody(t, resp, &actual)
@@ -151,6 +151,27 @@ type Core struct {
 	generateRootProgress [][]byte
 	generateRootLock     sync.Mutex
 
+	// generateRootStatusRequireAuth requires a valid client token to read
+	// the root generation status
+	generateRootStatusRequireAuth bool
+
+	// generateRootTimeout is how long a root generation attempt may remain
+	// incomplete before it is canceled
+	generateRootTimeout time.Duration
+
+	// generateRootMaxFailures is how many invalid keys in a row cancel a
+	// root generation attempt, and generateRootLockedUntil is when root
+	// generation may be used again after that happens. generateRootFailures
+	// counts the invalid keys since the last verified master key; it is
+	// kept across attempts so that canceling one does not clear it.
+	generateRootMaxFailures int
+	generateRootLockedUntil time.Time
+	generateRootFailures    int
+
+	// generateRootUpdatesPerMinute is how many root generation updates each
+	// source may make per minute; zero for no limit
+	generateRootUpdatesPerMinute int
+
 	// These variables holds the config and shares we have until we reach
 	// enough to verify the appropriate master key. Note that the same lock is
 	// used; this isn't time-critical so this shouldn't be a problem.
@@ -235,6 +256,22 @@ type CoreConfig struct {
 	AdvertiseAddr      string // Set as the leader address for HA
 	DefaultLeaseTTL    time.Duration
 	MaxLeaseTTL        time.Duration
+
+	// GenerateRootStatusRequireAuth requires a valid client token to read
+	// the status of a root generation attempt
+	GenerateRootStatusRequireAuth bool
+
+	// GenerateRootTimeout is how long a root generation attempt may remain
+	// incomplete before it is canceled; zero for the default
+	GenerateRootTimeout time.Duration
+
+	// GenerateRootMaxFailures is how many invalid keys in a row cancel a
+	// root generation attempt; zero for the default
+	GenerateRootMaxFailures int
+
+	// GenerateRootUpdatesPerMinute is how many root generation updates each
+	// source may make per minute; zero for no limit
+	GenerateRootUpdatesPerMinute int
 }
 
 // NewCore is used to construct a new core
@@ -252,6 +289,21 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 	if conf.DefaultLeaseTTL > conf.MaxLeaseTTL {
 		return nil, fmt.Errorf("cannot have DefaultLeaseTTL larger than MaxLeaseTTL")
 	}
+	if conf.GenerateRootTimeout == 0 {
+		conf.GenerateRootTimeout = defaultGenerateRootTimeout
+	}
+	if conf.GenerateRootTimeout < 0 {
+		return nil, fmt.Errorf("GenerateRootTimeout cannot be negative")
+	}
+	if conf.GenerateRootMaxFailures == 0 {
+		conf.GenerateRootMaxFailures = defaultGenerateRootMaxFailures
+	}
+	if conf.GenerateRootMaxFailures < 0 {
+		return nil, fmt.Errorf("GenerateRootMaxFailures cannot be negative")
+	}
+	if conf.GenerateRootUpdatesPerMinute < 0 {
+		return nil, fmt.Errorf("GenerateRootUpdatesPerMinute cannot be negative")
+	}
 
 	// Validate the advertise addr if its given to us
 	if conf.AdvertiseAddr != "" {
@@ -316,6 +368,11 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 		defaultLeaseTTL: conf.DefaultLeaseTTL,
 		maxLeaseTTL:     conf.MaxLeaseTTL,
 		cachingDisabled: conf.DisableCache,
+
+		generateRootStatusRequireAuth: conf.GenerateRootStatusRequireAuth,
+		generateRootTimeout:           conf.GenerateRootTimeout,
+		generateRootMaxFailures:       conf.GenerateRootMaxFailures,
+		generateRootUpdatesPerMinute:  conf.GenerateRootUpdatesPerMinute,
 	}
 
 	// Setup the backends
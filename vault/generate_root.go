@@ -2,15 +2,83 @@ package vault
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/helper/pgpkeys"
 	"github.com/hashicorp/vault/helper/xor"
+	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/shamir"
 )
 
+const (
+	// defaultGenerateRootTimeout is how long a root generation attempt may
+	// remain incomplete before it is canceled, unless configured otherwise
+	defaultGenerateRootTimeout = 10 * time.Minute
+
+	// defaultGenerateRootMaxFailures is how many invalid keys in a row
+	// cancel a root generation attempt, unless configured otherwise
+	defaultGenerateRootMaxFailures = 5
+
+	// generateRootLockoutPeriod is how long root generation is refused
+	// after an attempt is canceled for too many invalid keys
+	generateRootLockoutPeriod = 5 * time.Minute
+)
+
+// ErrGenerateRootNotStarted is returned when a key is provided but no root
+// generation is in progress.
+var ErrGenerateRootNotStarted = errors.New("no root generation in progress")
+
+// ErrGenerateRootNonceRequired is returned when a key is provided without
+// the nonce of the root generation attempt.
+var ErrGenerateRootNonceRequired = errors.New("the nonce of the root generation attempt must be provided")
+
+// ErrGenerateRootNonceMismatch is returned when a key is provided with a
+// nonce that does not match the in-progress root generation attempt. The
+// nonce of the attempt is deliberately not included.
+var ErrGenerateRootNonceMismatch = errors.New("incorrect nonce supplied for this root generation operation")
+
+// ErrGenerateRootInProgress is returned when a root generation is started
+// while another attempt is still in progress. The nonce of the running
+// attempt is included so that callers can join it instead; it may be left
+// empty to withhold it from callers that could not read the status.
+type ErrGenerateRootInProgress struct {
+	Nonce string
+}
+
+func (e *ErrGenerateRootInProgress) Error() string {
+	if e.Nonce == "" {
+		return "root generation already in progress"
+	}
+	return fmt.Sprintf("root generation already in progress (nonce: %s)", e.Nonce)
+}
+
+// ErrGenerateRootLockedOut is returned while root generation is refused
+// after an attempt was canceled for too many invalid keys.
+type ErrGenerateRootLockedOut struct {
+	Until time.Time
+}
+
+func (e *ErrGenerateRootLockedOut) Error() string {
+	return fmt.Sprintf("root generation is locked out until %s after too many invalid keys",
+		e.Until.Format(time.RFC3339))
+}
+
+// ErrGenerateRootCanceled is returned when an invalid key cancels the
+// in-progress root generation attempt. Err is the reason the key was
+// rejected.
+type ErrGenerateRootCanceled struct {
+	Err error
+}
+
+func (e *ErrGenerateRootCanceled) Error() string {
+	return fmt.Sprintf("%v; root generation canceled after too many invalid keys", e.Err)
+}
+
 // GenerateRootConfig holds the configuration for a root generation
 // command.
 type GenerateRootConfig struct {
@@ -18,15 +86,33 @@ type GenerateRootConfig struct {
 	PGPKey         string
 	PGPFingerprint string
 	OTP            string
+	StartTime      time.Time
+	Expiration     time.Time
+
+	// TTL is the requested TTL of the generated root token; zero means the
+	// token does not expire
+	TTL time.Duration
+
+	// rateLimits holds the update rate limit state of each source
+	rateLimits map[string]*generateRootRateLimit
+}
+
+// generateRootRateLimit is a token bucket limiting the root generation
+// updates made by a single source.
+type generateRootRateLimit struct {
+	tokens float64
+	last   time.Time
 }
 
 // GenerateRootResult holds the result of a root generation update
 // command
 type GenerateRootResult struct {
+	Nonce            string
 	Progress         int
 	Required         int
 	EncodedRootToken string
 	PGPFingerprint   string
+	TTL              time.Duration
 }
 
 // GenerateRoot is used to return the root generation progress (num shares)
@@ -42,10 +128,105 @@ func (c *Core) GenerateRootProgress() (int, error) {
 
 	c.generateRootLock.Lock()
 	defer c.generateRootLock.Unlock()
+	c.generateRootExpireLocked()
 
 	return len(c.generateRootProgress), nil
 }
 
+// GenerateRootStatusCheckToken is used to verify that the given client token
+// may read the root generation status. This always succeeds unless the core
+// is configured to require authentication for status reads.
+func (c *Core) GenerateRootStatusCheckToken(token string) error {
+	if !c.generateRootStatusRequireAuth {
+		return nil
+	}
+
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.sealed {
+		return ErrSealed
+	}
+	if c.standby {
+		return ErrStandby
+	}
+
+	if token == "" {
+		return logical.ErrPermissionDenied
+	}
+
+	te, err := c.tokenStore.Lookup(token)
+	if err != nil {
+		c.logger.Printf("[ERR] core: failed to lookup token: %v", err)
+		return ErrInternalError
+	}
+	if te == nil {
+		return logical.ErrPermissionDenied
+	}
+	return nil
+}
+
+// GenerateRootRateLimit records a root generation update from the given
+// client token, or from the remote address if the token is missing or not
+// valid. If the source has made too many updates it returns how long to wait
+// before the next update is allowed, otherwise zero. There is no limit if no
+// attempt is in progress.
+func (c *Core) GenerateRootRateLimit(clientToken, remoteAddr string) time.Duration {
+	if c.generateRootUpdatesPerMinute == 0 {
+		return 0
+	}
+
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.sealed || c.standby {
+		return 0
+	}
+
+	// Only a valid token identifies a client; anything else could be
+	// changed on every request to get a fresh limit
+	source := "addr:" + remoteAddr
+	if clientToken != "" {
+		te, err := c.tokenStore.Lookup(clientToken)
+		if err != nil {
+			c.logger.Printf("[ERR] core: failed to lookup token: %v", err)
+		} else if te != nil {
+			source = "token:" + te.ID
+		}
+	}
+
+	c.generateRootLock.Lock()
+	defer c.generateRootLock.Unlock()
+	c.generateRootExpireLocked()
+
+	if c.generateRootConfig == nil {
+		return 0
+	}
+	if c.generateRootConfig.rateLimits == nil {
+		c.generateRootConfig.rateLimits = make(map[string]*generateRootRateLimit)
+	}
+
+	// Refill the bucket based on the time since the last update
+	now := time.Now()
+	max := float64(c.generateRootUpdatesPerMinute)
+	perSecond := max / 60
+	limit, ok := c.generateRootConfig.rateLimits[source]
+	if !ok {
+		limit = &generateRootRateLimit{tokens: max}
+		c.generateRootConfig.rateLimits[source] = limit
+	} else {
+		limit.tokens += now.Sub(limit.last).Seconds() * perSecond
+		if limit.tokens > max {
+			limit.tokens = max
+		}
+	}
+	limit.last = now
+
+	if limit.tokens < 1 {
+		return time.Duration((1 - limit.tokens) / perSecond * float64(time.Second))
+	}
+	limit.tokens--
+	return 0
+}
+
 // GenerateRootConfig is used to read the root generation configuration
 // It stubbornly refuses to return the OTP if one is there.
 func (c *Core) GenerateRootConfiguration() (*GenerateRootConfig, error) {
@@ -60,6 +241,7 @@ func (c *Core) GenerateRootConfiguration() (*GenerateRootConfig, error) {
 
 	c.generateRootLock.Lock()
 	defer c.generateRootLock.Unlock()
+	c.generateRootExpireLocked()
 
 	// Copy the config if any
 	var conf *GenerateRootConfig
@@ -67,12 +249,31 @@ func (c *Core) GenerateRootConfiguration() (*GenerateRootConfig, error) {
 		conf = new(GenerateRootConfig)
 		*conf = *c.generateRootConfig
 		conf.OTP = ""
+		conf.rateLimits = nil
 	}
 	return conf, nil
 }
 
-// GenerateRootInit is used to initialize the root generation settings
-func (c *Core) GenerateRootInit(otp, pgpKey string) error {
+// GenerateRootInit is used to initialize the root generation settings. The
+// client token is only used to record who started the attempt in the audit
+// log.
+func (c *Core) GenerateRootInit(clientToken, otp, pgpKey string) error {
+	return c.GenerateRootInitWithTTL(clientToken, otp, pgpKey, 0)
+}
+
+// GenerateRootInitWithTTL is like GenerateRootInit, but also sets the TTL of
+// the root token that will be generated. The TTL is limited to the maximum
+// lease TTL.
+func (c *Core) GenerateRootInitWithTTL(clientToken, otp, pgpKey string, ttl time.Duration) error {
+	if ttl < 0 {
+		return fmt.Errorf("ttl cannot be negative")
+	}
+
+	// Limit the TTL like that of any other token
+	if c.maxLeaseTTL != 0 && ttl > c.maxLeaseTTL {
+		ttl = c.maxLeaseTTL
+	}
+
 	var fingerprint string
 	switch {
 	case len(otp) > 0:
@@ -109,10 +310,15 @@ func (c *Core) GenerateRootInit(otp, pgpKey string) error {
 
 	c.generateRootLock.Lock()
 	defer c.generateRootLock.Unlock()
+	c.generateRootExpireLocked()
+
+	if err := c.generateRootCheckLockoutLocked(); err != nil {
+		return err
+	}
 
 	// Prevent multiple concurrent root generations
 	if c.generateRootConfig != nil {
-		return fmt.Errorf("root generation already in progress")
+		return &ErrGenerateRootInProgress{Nonce: c.generateRootConfig.Nonce}
 	}
 
 	// Copy the configuration
@@ -121,11 +327,23 @@ func (c *Core) GenerateRootInit(otp, pgpKey string) error {
 		return err
 	}
 
+	if err := c.generateRootAudit(clientToken, logical.UpdateOperation, "sys/generate-root/attempt", map[string]interface{}{
+		"nonce":           generationNonce,
+		"pgp_fingerprint": fingerprint,
+		"ttl":             int64(ttl.Seconds()),
+	}, nil); err != nil {
+		return err
+	}
+
+	now := time.Now()
 	c.generateRootConfig = &GenerateRootConfig{
 		Nonce:          generationNonce,
 		OTP:            otp,
 		PGPKey:         pgpKey,
 		PGPFingerprint: fingerprint,
+		StartTime:      now,
+		Expiration:     now.Add(c.generateRootTimeout),
+		TTL:            ttl,
 	}
 
 	c.logger.Printf("[INFO] core: root generation initialized (nonce: %s)",
@@ -133,18 +351,9 @@ func (c *Core) GenerateRootInit(otp, pgpKey string) error {
 	return nil
 }
 
-// GenerateRootUpdate is used to provide a new key part
-func (c *Core) GenerateRootUpdate(key []byte, nonce string) (*GenerateRootResult, error) {
-	// Verify the key length
-	min, max := c.barrier.KeyLength()
-	max += shamir.ShareOverhead
-	if len(key) < min {
-		return nil, &ErrInvalidKey{fmt.Sprintf("key is shorter than minimum %d bytes", min)}
-	}
-	if len(key) > max {
-		return nil, &ErrInvalidKey{fmt.Sprintf("key is longer than maximum %d bytes", max)}
-	}
-
+// GenerateRootUpdate is used to provide a new key part. The client token is
+// only used to record who submitted the key in the audit log.
+func (c *Core) GenerateRootUpdate(clientToken string, key []byte, nonce string) (*GenerateRootResult, error) {
 	// Get the seal configuration
 	var config *SealConfig
 	var err error
@@ -177,35 +386,67 @@ func (c *Core) GenerateRootUpdate(key []byte, nonce string) (*GenerateRootResult
 
 	c.generateRootLock.Lock()
 	defer c.generateRootLock.Unlock()
+	c.generateRootExpireLocked()
+
+	if err := c.generateRootCheckLockoutLocked(); err != nil {
+		return nil, c.generateRootRejectLocked(clientToken, nonce, err)
+	}
 
 	// Ensure a generateRoot is in progress
 	if c.generateRootConfig == nil {
-		return nil, fmt.Errorf("no root generation in progress")
+		return nil, c.generateRootRejectLocked(clientToken, nonce, ErrGenerateRootNotStarted)
 	}
 
-	if nonce != c.generateRootConfig.Nonce {
-		return nil, fmt.Errorf("incorrect nonce supplied; nonce for this root generation operation is %s", c.generateRootConfig.Nonce)
+	if nonce == "" {
+		return nil, c.generateRootRejectLocked(clientToken, nonce, ErrGenerateRootNonceRequired)
+	}
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(c.generateRootConfig.Nonce)) != 1 {
+		return nil, c.generateRootRejectLocked(clientToken, nonce, ErrGenerateRootNonceMismatch)
 	}
 
-	// Check if we already have this piece
+	// Verify the key length
+	min, max := c.barrier.KeyLength()
+	max += shamir.ShareOverhead
+	if len(key) < min {
+		return nil, c.generateRootFailureLocked(clientToken, nonce, &ErrInvalidKey{fmt.Sprintf("key is shorter than minimum %d bytes", min)})
+	}
+	if len(key) > max {
+		return nil, c.generateRootFailureLocked(clientToken, nonce, &ErrInvalidKey{fmt.Sprintf("key is longer than maximum %d bytes", max)})
+	}
+
+	// Check if we already have this piece. A resubmitted key is most likely
+	// an operator mistake, so it is not counted as an invalid key.
 	for _, existing := range c.generateRootProgress {
 		if bytes.Equal(existing, key) {
-			return nil, nil
+			return nil, c.generateRootRejectLocked(clientToken, nonce,
+				fmt.Errorf("given key has already been provided during this generation operation"))
 		}
 	}
 
+	// Audit the submission before storing the key. The key itself is never
+	// part of the audit entry.
+	progress := len(c.generateRootProgress) + 1
+	if err := c.generateRootAudit(clientToken, logical.UpdateOperation, "sys/generate-root/update", map[string]interface{}{
+		"nonce":    nonce,
+		"progress": progress,
+		"required": config.SecretThreshold,
+	}, nil); err != nil {
+		return nil, err
+	}
+
 	// Store this key
 	c.generateRootProgress = append(c.generateRootProgress, key)
-	progress := len(c.generateRootProgress)
 
 	// Check if we don't have enough keys to unlock
 	if len(c.generateRootProgress) < config.SecretThreshold {
 		c.logger.Printf("[DEBUG] core: cannot generate root, have %d of %d keys",
 			progress, config.SecretThreshold)
 		return &GenerateRootResult{
+			Nonce:          c.generateRootConfig.Nonce,
 			Progress:       progress,
 			Required:       config.SecretThreshold,
 			PGPFingerprint: c.generateRootConfig.PGPFingerprint,
+			TTL:            c.generateRootConfig.TTL,
 		}, nil
 	}
 
@@ -218,24 +459,31 @@ func (c *Core) GenerateRootUpdate(key []byte, nonce string) (*GenerateRootResult
 		masterKey, err = shamir.Combine(c.generateRootProgress)
 		c.generateRootProgress = nil
 		if err != nil {
-			return nil, fmt.Errorf("failed to compute master key: %v", err)
+			c.logger.Printf("[ERR] core: root generation aborted, failed to compute master key: %v", err)
+			return nil, c.generateRootFailureLocked(clientToken, nonce, &ErrInvalidKey{fmt.Sprintf("failed to compute master key: %v", err)})
 		}
 	}
 
-	// Verify the master key
+	// Verify the master key. Individual shards cannot be checked on their
+	// own, so a key that does not belong is only caught here; the progress
+	// has already been cleared so the keys must be provided again.
 	if c.seal.RecoveryKeySupported() {
 		if err := c.seal.VerifyRecoveryKey(masterKey); err != nil {
 			c.logger.Printf("[ERR] core: root generation aborted, recovery key verification failed: %v", err)
-			return nil, err
+			return nil, c.generateRootFailureLocked(clientToken, nonce, &ErrInvalidKey{"provided keys do not match the recovery key"})
 		}
 	} else {
 		if err := c.barrier.VerifyMaster(masterKey); err != nil {
 			c.logger.Printf("[ERR] core: root generation aborted, master key verification failed: %v", err)
-			return nil, err
+			return nil, c.generateRootFailureLocked(clientToken, nonce, &ErrInvalidKey{"provided keys do not match the master key"})
 		}
 	}
 
-	te, err := c.tokenStore.rootToken()
+	// Only a verified master key shows the keys were genuine, since partial
+	// keys cannot be checked on their own
+	c.generateRootFailures = 0
+
+	te, err := c.tokenStore.rootTokenWithTTL(c.generateRootConfig.TTL)
 	if err != nil {
 		c.logger.Printf("[ERR] core: root token generation failed: %v", err)
 		return nil, err
@@ -285,11 +533,23 @@ func (c *Core) GenerateRootUpdate(key []byte, nonce string) (*GenerateRootResult
 		return nil, fmt.Errorf("unreachable condition")
 	}
 
+	if err := c.generateRootAudit(clientToken, logical.UpdateOperation, "sys/generate-root/update", map[string]interface{}{
+		"nonce":    nonce,
+		"progress": progress,
+		"required": config.SecretThreshold,
+		"complete": true,
+	}, nil); err != nil {
+		c.tokenStore.Revoke(te.ID)
+		return nil, err
+	}
+
 	results := &GenerateRootResult{
+		Nonce:            c.generateRootConfig.Nonce,
 		Progress:         progress,
 		Required:         config.SecretThreshold,
 		EncodedRootToken: base64.StdEncoding.EncodeToString(tokenBytes),
 		PGPFingerprint:   c.generateRootConfig.PGPFingerprint,
+		TTL:              c.generateRootConfig.TTL,
 	}
 
 	c.logger.Printf("[INFO] core: root generation finished (nonce: %s)",
@@ -300,8 +560,9 @@ func (c *Core) GenerateRootUpdate(key []byte, nonce string) (*GenerateRootResult
 	return results, nil
 }
 
-// GenerateRootCancel is used to cancel an in-progress root generation
-func (c *Core) GenerateRootCancel() error {
+// GenerateRootCancel is used to cancel an in-progress root generation. The
+// client token is only used to record who canceled it in the audit log.
+func (c *Core) GenerateRootCancel(clientToken string) error {
 	c.stateLock.RLock()
 	defer c.stateLock.RUnlock()
 	if c.sealed {
@@ -314,8 +575,106 @@ func (c *Core) GenerateRootCancel() error {
 	c.generateRootLock.Lock()
 	defer c.generateRootLock.Unlock()
 
+	var nonce string
+	if c.generateRootConfig != nil {
+		nonce = c.generateRootConfig.Nonce
+	}
+	if err := c.generateRootAudit(clientToken, logical.DeleteOperation, "sys/generate-root/attempt", map[string]interface{}{
+		"nonce": nonce,
+	}, nil); err != nil {
+		return err
+	}
+
 	// Clear any progress or config
 	c.generateRootConfig = nil
 	c.generateRootProgress = nil
 	return nil
 }
+
+// generateRootAudit records a root generation lifecycle event with the audit
+// broker. Root generation does not go through the request handling path, so
+// the entry is built here from the client token and the given data, which
+// must never contain key material. If the request was rejected, outerErr is
+// the error given to the caller.
+func (c *Core) generateRootAudit(clientToken string, op logical.Operation, path string, data map[string]interface{}, outerErr error) error {
+	req := &logical.Request{
+		Operation:   op,
+		Path:        path,
+		ClientToken: clientToken,
+		Data:        data,
+	}
+	auth := &logical.Auth{
+		ClientToken: clientToken,
+	}
+	if err := c.auditBroker.LogRequest(auth, req, outerErr); err != nil {
+		c.logger.Printf("[ERR] core: failed to audit request with path %s: %v",
+			req.Path, err)
+		return errors.New("failed to audit request, cannot continue")
+	}
+	return nil
+}
+
+// generateRootCheckLockoutLocked returns an error if root generation is
+// locked out after too many invalid keys. The generateRootLock must be held.
+func (c *Core) generateRootCheckLockoutLocked() error {
+	if time.Now().Before(c.generateRootLockedUntil) {
+		return &ErrGenerateRootLockedOut{Until: c.generateRootLockedUntil}
+	}
+	return nil
+}
+
+// generateRootRejectLocked records a rejected root generation update in the
+// audit log and returns the error to give the caller. The generateRootLock
+// must be held.
+func (c *Core) generateRootRejectLocked(clientToken, nonce string, err error) error {
+	if auditErr := c.generateRootAudit(clientToken, logical.UpdateOperation, "sys/generate-root/update", map[string]interface{}{
+		"nonce": nonce,
+	}, err); auditErr != nil {
+		return auditErr
+	}
+	return err
+}
+
+// generateRootFailureLocked records an invalid key and returns the error to
+// give the caller. Once too many invalid keys are provided without a verified
+// master key in between, the in-progress attempt is canceled and root
+// generation is locked out for a while. The generateRootLock must be held.
+func (c *Core) generateRootFailureLocked(clientToken, nonce string, err error) error {
+	c.generateRootFailures++
+	if c.generateRootFailures < c.generateRootMaxFailures {
+		return c.generateRootRejectLocked(clientToken, nonce, err)
+	}
+
+	c.generateRootLockedUntil = time.Now().Add(generateRootLockoutPeriod)
+	c.logger.Printf("[WARN] core: root generation canceled after %d invalid keys, locked out until %s (nonce: %s)",
+		c.generateRootFailures, c.generateRootLockedUntil.Format(time.RFC3339), c.generateRootConfig.Nonce)
+	c.generateRootFailures = 0
+	err = c.generateRootRejectLocked(clientToken, nonce, &ErrGenerateRootCanceled{Err: err})
+	c.generateRootAutoCancelLocked(errors.New("too many invalid keys"))
+	return err
+}
+
+// generateRootExpireLocked cancels the in-progress root generation attempt
+// if it has not completed before its expiration. The generateRootLock must
+// be held.
+func (c *Core) generateRootExpireLocked() {
+	if c.generateRootConfig == nil || time.Now().Before(c.generateRootConfig.Expiration) {
+		return
+	}
+
+	c.logger.Printf("[INFO] core: root generation attempt expired (nonce: %s)",
+		c.generateRootConfig.Nonce)
+	c.generateRootAutoCancelLocked(errors.New("root generation attempt expired"))
+}
+
+// generateRootAutoCancelLocked cancels the in-progress root generation
+// attempt on behalf of the core, recording the reason in the audit log. The
+// attempt is canceled even if it cannot be audited. The generateRootLock must
+// be held.
+func (c *Core) generateRootAutoCancelLocked(reason error) {
+	c.generateRootAudit("", logical.DeleteOperation, "sys/generate-root/attempt", map[string]interface{}{
+		"nonce": c.generateRootConfig.Nonce,
+	}, reason)
+	c.generateRootConfig = nil
+	c.generateRootProgress = nil
+}
@@ -2,11 +2,16 @@ package vault
 
 import (
 	"encoding/base64"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/audit"
 	"github.com/hashicorp/vault/helper/pgpkeys"
 	"github.com/hashicorp/vault/helper/xor"
+	"github.com/hashicorp/vault/logical"
 )
 
 func TestCore_GenerateRoot_Lifecycle(t *testing.T) {
@@ -20,7 +25,7 @@ func TestCore_GenerateRoot_Lifecycle(t *testing.T) {
 
 func testCore_GenerateRoot_Lifecycle_Common(t *testing.T, c *Core, keys [][]byte) {
 	// Verify update not allowed
-	if _, err := c.GenerateRootUpdate(keys[0], ""); err == nil {
+	if _, err := c.GenerateRootUpdate("", keys[0], ""); err == nil {
 		t.Fatalf("no root generation in progress")
 	}
 
@@ -43,7 +48,7 @@ func testCore_GenerateRoot_Lifecycle_Common(t *testing.T, c *Core, keys [][]byte
 	}
 
 	// Cancel should be idempotent
-	err = c.GenerateRootCancel()
+	err = c.GenerateRootCancel("")
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -54,7 +59,7 @@ func testCore_GenerateRoot_Lifecycle_Common(t *testing.T, c *Core, keys [][]byte
 	}
 
 	// Start a root generation
-	err = c.GenerateRootInit(base64.StdEncoding.EncodeToString(otpBytes), "")
+	err = c.GenerateRootInit("", base64.StdEncoding.EncodeToString(otpBytes), "")
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -66,7 +71,7 @@ func testCore_GenerateRoot_Lifecycle_Common(t *testing.T, c *Core, keys [][]byte
 	}
 
 	// Cancel should be clear
-	err = c.GenerateRootCancel()
+	err = c.GenerateRootCancel("")
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -96,16 +101,28 @@ func testCore_GenerateRoot_Init_Common(t *testing.T, c *Core) {
 		t.Fatal(err)
 	}
 
-	err = c.GenerateRootInit(base64.StdEncoding.EncodeToString(otpBytes), "")
+	err = c.GenerateRootInit("", base64.StdEncoding.EncodeToString(otpBytes), "")
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
 
-	// Second should fail
-	err = c.GenerateRootInit("", pgpkeys.TestPubKey1)
+	conf, err := c.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Second should fail and report the running attempt
+	err = c.GenerateRootInit("", "", pgpkeys.TestPubKey1)
 	if err == nil {
 		t.Fatalf("should fail")
 	}
+	inProgress, ok := err.(*ErrGenerateRootInProgress)
+	if !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+	if inProgress.Nonce != conf.Nonce {
+		t.Fatalf("bad: expected nonce %s, got %s", conf.Nonce, inProgress.Nonce)
+	}
 }
 
 func TestCore_GenerateRoot_InvalidMasterNonce(t *testing.T) {
@@ -133,7 +150,7 @@ func testCore_GenerateRoot_InvalidMasterNonce_Common(t *testing.T, c *Core, keys
 		t.Fatal(err)
 	}
 
-	err = c.GenerateRootInit(base64.StdEncoding.EncodeToString(otpBytes), "")
+	err = c.GenerateRootInit("", base64.StdEncoding.EncodeToString(otpBytes), "")
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -148,14 +165,14 @@ func testCore_GenerateRoot_InvalidMasterNonce_Common(t *testing.T, c *Core, keys
 	}
 
 	// Provide the nonce (invalid)
-	_, err = c.GenerateRootUpdate(keys[0], "abcd")
+	_, err = c.GenerateRootUpdate("", keys[0], "abcd")
 	if err == nil {
 		t.Fatalf("expected error")
 	}
 
 	// Provide the master (invalid)
 	for _, key := range keys {
-		_, err = c.GenerateRootUpdate(key, rgconf.Nonce)
+		_, err = c.GenerateRootUpdate("", key, rgconf.Nonce)
 	}
 	if err == nil {
 		t.Fatalf("expected error")
@@ -179,7 +196,7 @@ func testCore_GenerateRoot_Update_OTP_Common(t *testing.T, c *Core, keys [][]byt
 
 	otp := base64.StdEncoding.EncodeToString(otpBytes)
 	// Start a root generation
-	err = c.GenerateRootInit(otp, "")
+	err = c.GenerateRootInit("", otp, "")
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -196,7 +213,7 @@ func testCore_GenerateRoot_Update_OTP_Common(t *testing.T, c *Core, keys [][]byt
 	// Provide the keys
 	var result *GenerateRootResult
 	for _, key := range keys {
-		result, err = c.GenerateRootUpdate(key, rkconf.Nonce)
+		result, err = c.GenerateRootUpdate("", key, rkconf.Nonce)
 		if err != nil {
 			t.Fatalf("err: %v", err)
 		}
@@ -259,7 +276,7 @@ func TestCore_GenerateRoot_Update_PGP(t *testing.T) {
 
 func testCore_GenerateRoot_Update_PGP_Common(t *testing.T, c *Core, keys [][]byte) {
 	// Start a root generation
-	err := c.GenerateRootInit("", pgpkeys.TestPubKey1)
+	err := c.GenerateRootInit("", "", pgpkeys.TestPubKey1)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -276,7 +293,7 @@ func testCore_GenerateRoot_Update_PGP_Common(t *testing.T, c *Core, keys [][]byt
 	// Provide the keys
 	var result *GenerateRootResult
 	for _, key := range keys {
-		result, err = c.GenerateRootUpdate(key, rkconf.Nonce)
+		result, err = c.GenerateRootUpdate("", key, rkconf.Nonce)
 		if err != nil {
 			t.Fatalf("err: %v", err)
 		}
@@ -328,3 +345,300 @@ func testCore_GenerateRoot_Update_PGP_Common(t *testing.T, c *Core, keys [][]byt
 		t.Fatalf("bad: %#v", *te)
 	}
 }
+
+func TestCore_GenerateRoot_Expire(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+	if c.generateRootTimeout != defaultGenerateRootTimeout {
+		t.Fatalf("bad: %s", c.generateRootTimeout)
+	}
+
+	otpBytes, err := GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	err = c.GenerateRootInit("", otp, "")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	conf, err := c.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if conf == nil {
+		t.Fatal("should have a config")
+	}
+	if conf.StartTime.IsZero() || !conf.Expiration.Equal(conf.StartTime.Add(defaultGenerateRootTimeout)) {
+		t.Fatalf("bad: %#v", conf)
+	}
+
+	// Move the attempt past its expiration
+	c.generateRootLock.Lock()
+	c.generateRootConfig.Expiration = time.Now().Add(-time.Second)
+	c.generateRootLock.Unlock()
+
+	conf, err = c.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if conf != nil {
+		t.Fatalf("bad: %#v", conf)
+	}
+
+	// A fresh attempt can now be started
+	err = c.GenerateRootInit("", otp, "")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestCore_GenerateRoot_Audit(t *testing.T) {
+	c, master, _ := TestCoreUnsealedWithConfig(t, &CoreConfig{
+		GenerateRootMaxFailures: 2,
+	})
+	noop := &NoopAudit{}
+	c.auditBackends["noop"] = func(config *audit.BackendConfig) (audit.Backend, error) {
+		noop.Config = config
+		return noop, nil
+	}
+	me := &MountEntry{
+		Table: auditTableType,
+		Path:  "foo",
+		Type:  "noop",
+	}
+	if err := c.enableAudit(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	otpBytes, err := GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	if err := c.GenerateRootInit("foo", otp, ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	conf, err := c.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := c.GenerateRootUpdate("bar", master, conf.Nonce); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := c.GenerateRootCancel("baz"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Rejected keys are audited, as is the cancel after too many of them
+	if err := c.GenerateRootInit("foo", otp, ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	conf2, err := c.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := c.GenerateRootUpdate("qux", master, "abcd"); err != ErrGenerateRootNonceMismatch {
+		t.Fatalf("bad: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.GenerateRootUpdate("qux", []byte("short"), conf2.Nonce); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if conf, _ := c.GenerateRootConfiguration(); conf != nil {
+		t.Fatalf("bad: %#v", conf)
+	}
+
+	expected := []struct {
+		token    string
+		path     string
+		data     map[string]interface{}
+		rejected bool
+	}{
+		{"foo", "sys/generate-root/attempt", map[string]interface{}{
+			"nonce":           conf.Nonce,
+			"pgp_fingerprint": "",
+			"ttl":             int64(0),
+		}, false},
+		{"bar", "sys/generate-root/update", map[string]interface{}{
+			"nonce":    conf.Nonce,
+			"progress": 1,
+			"required": 1,
+		}, false},
+		{"bar", "sys/generate-root/update", map[string]interface{}{
+			"nonce":    conf.Nonce,
+			"progress": 1,
+			"required": 1,
+			"complete": true,
+		}, false},
+		{"baz", "sys/generate-root/attempt", map[string]interface{}{
+			"nonce": "",
+		}, false},
+		{"foo", "sys/generate-root/attempt", map[string]interface{}{
+			"nonce":           conf2.Nonce,
+			"pgp_fingerprint": "",
+			"ttl":             int64(0),
+		}, false},
+		{"qux", "sys/generate-root/update", map[string]interface{}{
+			"nonce": "abcd",
+		}, true},
+		{"qux", "sys/generate-root/update", map[string]interface{}{
+			"nonce": conf2.Nonce,
+		}, true},
+		{"qux", "sys/generate-root/update", map[string]interface{}{
+			"nonce": conf2.Nonce,
+		}, true},
+		{"", "sys/generate-root/attempt", map[string]interface{}{
+			"nonce": conf2.Nonce,
+		}, true},
+	}
+	if len(noop.Req) != len(expected) {
+		t.Fatalf("bad: %d audit entries", len(noop.Req))
+	}
+	for i, e := range expected {
+		req := noop.Req[i]
+		if req.Path != e.path || req.ClientToken != e.token || noop.ReqAuth[i].ClientToken != e.token {
+			t.Fatalf("bad: entry %d: %#v", i, req)
+		}
+		if !reflect.DeepEqual(req.Data, e.data) {
+			t.Fatalf("bad: entry %d:\nexpected: %#v\nactual: %#v", i, e.data, req.Data)
+		}
+		if (noop.ReqErrs[i] != nil) != e.rejected {
+			t.Fatalf("bad: entry %d: %v", i, noop.ReqErrs[i])
+		}
+	}
+}
+
+func TestCore_GenerateRoot_TTL(t *testing.T) {
+	c, master, _ := TestCoreUnsealed(t)
+
+	otpBytes, err := GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp := base64.StdEncoding.EncodeToString(otpBytes)
+
+	if err := c.GenerateRootInitWithTTL("", otp, "", -time.Second); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// The TTL is limited to the maximum lease TTL
+	if err := c.GenerateRootInitWithTTL("", otp, "", 100000*time.Hour); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	conf, err := c.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if conf.TTL != c.maxLeaseTTL {
+		t.Fatalf("bad: %#v", conf)
+	}
+	if err := c.GenerateRootCancel(""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := c.GenerateRootInitWithTTL("", otp, "", 2*time.Second); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	conf, err = c.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if conf.TTL != 2*time.Second {
+		t.Fatalf("bad: %#v", conf)
+	}
+
+	result, err := c.GenerateRootUpdate("", master, conf.Nonce)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if result.TTL != 2*time.Second {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	tokenBytes, err := xor.XORBase64(result.EncodedRootToken, otp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := uuid.FormatUUID(tokenBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "auth/token/lookup-self",
+		ClientToken: token,
+	}
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if ttl := resp.Data["ttl"].(int64); ttl <= 0 || ttl > 2 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if resp.Data["creation_ttl"].(int64) != 2 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// The token should be revoked once the TTL passes
+	time.Sleep(3 * time.Second)
+	te, err := c.tokenStore.Lookup(token)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if te != nil {
+		t.Fatalf("token should have been revoked: %#v", te)
+	}
+}
+
+func TestCore_GenerateRoot_Nonce(t *testing.T) {
+	c, master, _ := TestCoreUnsealed(t)
+
+	otpBytes, err := GenerateRandBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GenerateRootInit("", base64.StdEncoding.EncodeToString(otpBytes), ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	conf, err := c.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Missing nonce
+	if _, err := c.GenerateRootUpdate("", master, ""); err != ErrGenerateRootNonceRequired {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// Mismatched nonce
+	_, err = c.GenerateRootUpdate("", master, "abcd")
+	if err != ErrGenerateRootNonceMismatch {
+		t.Fatalf("bad: %v", err)
+	}
+	if strings.Contains(err.Error(), conf.Nonce) {
+		t.Fatalf("nonce leaked: %v", err)
+	}
+
+	// Neither should have made progress
+	num, err := c.GenerateRootProgress()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if num != 0 {
+		t.Fatalf("bad: %d", num)
+	}
+
+	// Correct nonce
+	result, err := c.GenerateRootUpdate("", master, conf.Nonce)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if result.Nonce != conf.Nonce {
+		t.Fatalf("bad: %#v", result)
+	}
+}
@@ -68,6 +68,13 @@ func TestCore(t *testing.T) *Core {
 // TestCoreWithSeal returns a pure in-memory, uninitialized core with the
 // specified seal for testing.
 func TestCoreWithSeal(t *testing.T, testSeal Seal) *Core {
+	return TestCoreWithConfig(t, &CoreConfig{Seal: testSeal})
+}
+
+// TestCoreWithConfig returns a pure in-memory, uninitialized core for
+// testing using the given config. The physical, audit, logical and
+// credential backends and the logger are filled in if not set.
+func TestCoreWithConfig(t *testing.T, conf *CoreConfig) *Core {
 	noopAudits := map[string]audit.Factory{
 		"noop": func(config *audit.BackendConfig) (audit.Backend, error) {
 			view := &logical.InmemStorage{}
@@ -106,19 +113,22 @@ func TestCoreWithSeal(t *testing.T, testSeal Seal) *Core {
 		logicalBackends[backendName] = backendFactory
 	}
 
-	logger := log.New(os.Stderr, "", log.LstdFlags)
-	physicalBackend := physical.NewInmem(logger)
-	conf := &CoreConfig{
-		Physical:           physicalBackend,
-		AuditBackends:      noopAudits,
-		LogicalBackends:    logicalBackends,
-		CredentialBackends: noopBackends,
-		DisableMlock:       true,
-		Logger:             logger,
+	if conf.Logger == nil {
+		conf.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	if conf.Physical == nil {
+		conf.Physical = physical.NewInmem(conf.Logger)
 	}
-	if testSeal != nil {
-		conf.Seal = testSeal
+	if conf.AuditBackends == nil {
+		conf.AuditBackends = noopAudits
 	}
+	if conf.LogicalBackends == nil {
+		conf.LogicalBackends = logicalBackends
+	}
+	if conf.CredentialBackends == nil {
+		conf.CredentialBackends = noopBackends
+	}
+	conf.DisableMlock = true
 
 	c, err := NewCore(conf)
 	if err != nil {
@@ -144,7 +154,13 @@ func TestCoreInit(t *testing.T, core *Core) ([]byte, string) {
 // TestCoreUnsealed returns a pure in-memory core that is already
 // initialized and unsealed.
 func TestCoreUnsealed(t *testing.T) (*Core, []byte, string) {
-	core := TestCore(t)
+	return TestCoreUnsealedWithConfig(t, &CoreConfig{})
+}
+
+// TestCoreUnsealedWithConfig returns a pure in-memory core using the given
+// config that is already initialized and unsealed.
+func TestCoreUnsealedWithConfig(t *testing.T, conf *CoreConfig) (*Core, []byte, string) {
+	core := TestCoreWithConfig(t, conf)
 	key, token := TestCoreInit(t, core)
 	if _, err := core.Unseal(TestKeyCopy(key)); err != nil {
 		t.Fatalf("unseal err: %s", err)
@@ -470,15 +470,39 @@ func (ts *TokenStore) SaltID(id string) string {
 
 // RootToken is used to generate a new token with root privileges and no parent
 func (ts *TokenStore) rootToken() (*TokenEntry, error) {
+	return ts.rootTokenWithTTL(0)
+}
+
+// rootTokenWithTTL is like rootToken, but if the TTL is non-zero the token
+// is registered with the expiration manager so that it is revoked once the
+// TTL passes.
+func (ts *TokenStore) rootTokenWithTTL(ttl time.Duration) (*TokenEntry, error) {
 	te := &TokenEntry{
 		Policies:     []string{"root"},
 		Path:         "auth/token/root",
 		DisplayName:  "root",
 		CreationTime: time.Now().Unix(),
+		TTL:          ttl,
 	}
 	if err := ts.create(te); err != nil {
 		return nil, err
 	}
+
+	if ttl > 0 {
+		auth := &logical.Auth{
+			DisplayName: te.DisplayName,
+			Policies:    te.Policies,
+			LeaseOptions: logical.LeaseOptions{
+				TTL: ttl,
+			},
+			ClientToken: te.ID,
+			Accessor:    te.Accessor,
+		}
+		if err := ts.expiration.RegisterAuth(te.Path, auth); err != nil {
+			ts.Revoke(te.ID)
+			return nil, err
+		}
+	}
 	return te, nil
 }
 